@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/keyauth"
+)
+
+// AdminAuth only lets requests with the admin token as bearer token through.
+func AdminAuth(token string) fiber.Handler {
+	return keyauth.New(keyauth.Config{
+		Validator: func(c *fiber.Ctx, key string) (bool, error) {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(token)) != 1 {
+				return false, keyauth.ErrMissingOrMalformedAPIKey
+			}
+			return true, nil
+		},
+	})
+}
+
+// AdminMaintenanceRequest is the body accepted to set a maintenance message.
+type AdminMaintenanceRequest struct {
+	Message string `json:"message"`
+}
+
+// AdminRoutes registers the admin endpoints on the router.
+func AdminRoutes(router fiber.Router, maintenance *Maintenance, sm *StateManager, webhooks *Webhooks, calibrations *Calibrations, history *History, readings *Readings) {
+	// API requests per API key name
+	router.Get("/api-keys", func(c *fiber.Ctx) error {
+		return c.JSON(sm.ApiKeyRequests())
+	})
+
+	// Request counters and the analytics of the last ?days=, up to 90 days
+	router.Get("/stats", func(c *fiber.Ctx) error {
+		days := c.QueryInt("days", MAX_ANALYTICS_DAYS)
+		if days < 1 {
+			return fiber.NewError(fiber.StatusBadRequest, "days must be at least 1")
+		}
+		return c.JSON(sm.RequestStats(days))
+	})
+
+	router.Get("/maintenance", func(c *fiber.Ctx) error {
+		return c.JSON(MaintenanceEvent{Message: maintenance.Message()})
+	})
+
+	router.Put("/maintenance", func(c *fiber.Ctx) error {
+		var req AdminMaintenanceRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		// Form values share the request buffer, which Fiber reuses, and the message is kept
+		maintenance.Set(strings.Clone(req.Message))
+		return c.JSON(MaintenanceEvent{Message: maintenance.Message()})
+	})
+
+	router.Delete("/maintenance", func(c *fiber.Ctx) error {
+		maintenance.Set("")
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	// Webhook delivery log, latest first, optionally filtered by ?status=pending|delivered|failed
+	router.Get("/webhooks/deliveries", func(c *fiber.Ctx) error {
+		return c.JSON(webhooks.Deliveries(c.Query("status")))
+	})
+
+	// Deliver a failed delivery again
+	router.Post("/webhooks/deliveries/:id/replay", func(c *fiber.Ctx) error {
+		d, err := webhooks.Replay(c.Params("id"))
+		switch {
+		case errors.Is(err, ErrDeliveryNotFound):
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		case errors.Is(err, ErrDeliveryNotFailed):
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		case err != nil:
+			return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+		}
+		return c.Status(fiber.StatusAccepted).JSON(d)
+	})
+
+	router.Get("/calibrations", func(c *fiber.Ctx) error {
+		return c.JSON(calibrations.All())
+	})
+
+	// Add a calibration, correcting the readings from its effective date on
+	addCalibration := func(c *fiber.Ctx, calibration Calibration) error {
+		if err := calibrations.Add(calibration); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if err := history.Recalibrate(calibration.Source, calibration.EffectiveFrom); err != nil {
+			return err
+		}
+		readings.Update()
+		return c.Status(fiber.StatusCreated).JSON(calibration)
+	}
+
+	router.Post("/calibrations", func(c *fiber.Ctx) error {
+		var calibration Calibration
+		if err := c.BodyParser(&calibration); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		// Form values share the request buffer, which Fiber reuses, and calibrations are kept
+		calibration.Source = strings.Clone(calibration.Source)
+		calibration.Type = strings.Clone(calibration.Type)
+		calibration.Note = strings.Clone(calibration.Note)
+		return addCalibration(c, calibration)
+	})
+
+	// Add an offset calibration from a reading of a reference thermometer, by default of the Monnit sensor now
+	router.Post("/calibrations/reference", func(c *fiber.Ctx) error {
+		reference := ReferenceReading{Source: SOURCE_MONNIT, Time: time.Now()}
+		if err := c.BodyParser(&reference); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		reference.Source = strings.Clone(reference.Source)
+		calibration, err := NewReferenceCalibration(history, reference)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+		}
+		return addCalibration(c, calibration)
+	})
+}
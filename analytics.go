@@ -0,0 +1,84 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Classes of clients told apart by their user agent
+const (
+	CLIENT_BOT     = "bot"
+	CLIENT_SIGNAGE = "signage"
+	CLIENT_BROWSER = "browser"
+	// CLIENT_OTHER are scripts, API clients and anything else
+	CLIENT_OTHER = "other"
+)
+
+// botAgents are parts of the user agents of crawlers, link previews and monitoring, in lower case
+var botAgents = []string{"bot", "crawl", "spider", "slurp", "facebookexternalhit", "preview", "headless"}
+
+// routeConstraint matches the constraints of route parameters, e.g. <regex(...)>
+var routeConstraint = regexp.MustCompile(`<[^>]*>`)
+
+// ClassifyClient tells signage displays, bots and browsers apart by their user agent. Signage displays have
+// any of the signage agents in theirs, ignoring case.
+func ClassifyClient(userAgent string, signageAgents []string) string {
+	ua := strings.ToLower(userAgent)
+	for _, agent := range signageAgents {
+		if agent != "" && strings.Contains(ua, strings.ToLower(agent)) {
+			return CLIENT_SIGNAGE
+		}
+	}
+	for _, agent := range botAgents {
+		if strings.Contains(ua, agent) {
+			return CLIENT_BOT
+		}
+	}
+	if strings.HasPrefix(ua, "mozilla/") {
+		return CLIENT_BROWSER
+	}
+	return CLIENT_OTHER
+}
+
+// AnalyticsDate returns the date the analytics of a time are kept under, in the local time zone.
+func AnalyticsDate(t time.Time, location *time.Location) string {
+	return t.In(location).Format(DATE_ONLY_FORMAT)
+}
+
+// Analytics records every request in the state by client class, route and image type, as set in the "image_type"
+// local by the image handlers, into the analytics of the day.
+func Analytics(sm *StateManager, location *time.Location, signageAgents []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+
+		now := time.Now()
+		client := ClassifyClient(c.Get(fiber.HeaderUserAgent), signageAgents)
+		// Values of the request, e.g. c.Method() and route parameters, are only valid until it returns, so the
+		// route's own method is used and the image type is copied.
+		route := "unmatched"
+		if unmatched, _ := c.Locals("unmatched").(bool); !unmatched {
+			r := c.Route()
+			route = r.Method + " " + routeConstraint.ReplaceAllString(r.Path, "")
+		}
+		image, _ := c.Locals("image_type").(string)
+		image = strings.Clone(image)
+
+		sm.SetLastRequest(now)
+		if client == CLIENT_BOT {
+			sm.IncrementBotRequests()
+		}
+		sm.RecordRequest(AnalyticsDate(now, location), client, route, image)
+
+		return err
+	}
+}
+
+// MarkUnmatched marks requests as not handled by any route for the analytics. It must be registered after all routes,
+// and passes them on to the not found handling of Fiber.
+func MarkUnmatched(c *fiber.Ctx) error {
+	c.Locals("unmatched", true)
+	return c.Next()
+}
@@ -8,3 +8,16 @@ type ApiMessage struct {
 	Temperature  Temperature `json:"temperature"`
 	LastModified MessageDate `json:"datetime"`
 }
+
+// ApiError is the body of API error responses.
+type ApiError struct {
+	Error string `json:"error"`
+}
+
+// ApiConditions is the swimming condition of the latest reading, together with all condition bands.
+type ApiConditions struct {
+	Temperature  Temperature    `json:"temperature"`
+	LastModified MessageDate    `json:"datetime"`
+	Condition    *ConditionBand `json:"condition"`
+	Bands        Conditions     `json:"bands"`
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	UNIT_CELSIUS = "°C"
+
+	// SOURCE_MONNIT is the source of readings of the Monnit sensor
+	SOURCE_MONNIT = "monnit"
+)
+
+// ApiV2Response wraps the readings with metadata describing them, so it can be extended without breaking clients.
+type ApiV2Response struct {
+	Metadata ApiV2Metadata  `json:"metadata"`
+	Readings []ApiV2Reading `json:"readings"`
+}
+
+// ApiV2Metadata describes the sensor and how current its readings are.
+type ApiV2Metadata struct {
+	Sensor   ApiV2Sensor   `json:"sensor"`
+	Unit     string        `json:"unit"`
+	Location ApiV2Location `json:"location"`
+	// Source of the latest reading
+	Source string `json:"source"`
+	// GeneratedAt is when the response was generated
+	GeneratedAt time.Time `json:"generated_at"`
+	// LastReadingAt is the time of the latest reading, if there is any
+	LastReadingAt *time.Time `json:"last_reading_at,omitempty"`
+	// AgeSeconds is how long ago the latest reading was taken
+	AgeSeconds int `json:"age_seconds"`
+	// Stale is set when the latest reading is older than StaleAfterSeconds, e.g. while the sensor is offline
+	Stale             bool `json:"stale"`
+	StaleAfterSeconds int  `json:"stale_after_seconds"`
+}
+
+type ApiV2Sensor struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ApiV2Location struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Timezone  string  `json:"timezone"`
+}
+
+// ApiV2Reading is a single reading, identified by its Monnit DataMessageGUID, or the source and ID for other sources.
+type ApiV2Reading struct {
+	Id    string  `json:"id"`
+	Value float64 `json:"value"`
+	// RawValue is the value measured before calibration, if a calibration applied
+	RawValue  *float64     `json:"raw_value,omitempty"`
+	Unit      string       `json:"unit"`
+	Timestamp time.Time    `json:"timestamp"`
+	Source    string       `json:"source"`
+	Health    *ApiV2Health `json:"health,omitempty"`
+	// Measurements of sensors measuring more than the temperature, e.g. the humidity
+	Measurements []Measurement `json:"measurements,omitempty"`
+	// Rejected is the reason the reading failed validation, such readings aren't shown or used in statistics
+	Rejected string `json:"rejected,omitempty"`
+}
+
+// ApiV2Health is the state of the sensor when it sent the reading, as far as known.
+type ApiV2Health struct {
+	Battery        *int     `json:"battery,omitempty"`
+	SignalStrength *int     `json:"signal_strength,omitempty"`
+	Voltage        *float64 `json:"voltage,omitempty"`
+}
+
+// ToApiV2Reading converts the reading, leaving out health fields that weren't reported.
+func (r Reading) ToApiV2Reading() ApiV2Reading {
+	reading := ApiV2Reading{
+		Id:           r.GUID,
+		Value:        r.Temperature,
+		RawValue:     r.RawTemperature,
+		Unit:         UNIT_CELSIUS,
+		Timestamp:    r.Time.UTC(),
+		Source:       r.Source,
+		Measurements: r.Measurements,
+		Rejected:     r.Rejected,
+	}
+
+	var health ApiV2Health
+	if r.Battery != 0 {
+		health.Battery = &r.Battery
+	}
+	if r.SignalStrength != 0 {
+		health.SignalStrength = &r.SignalStrength
+	}
+	if r.Voltage != 0 {
+		health.Voltage = &r.Voltage
+	}
+	if health != (ApiV2Health{}) {
+		reading.Health = &health
+	}
+
+	return reading
+}
+
+// NewApiV2Metadata describes the readings for the latest reading.
+func NewApiV2Metadata(cfg *Config, last Reading) ApiV2Metadata {
+	now := time.Now()
+	metadata := ApiV2Metadata{
+		Sensor: ApiV2Sensor{Id: cfg.SensorId, Name: cfg.SensorName},
+		Unit:   UNIT_CELSIUS,
+		Location: ApiV2Location{
+			Name:      cfg.LocationName,
+			Latitude:  cfg.LocationLatitude,
+			Longitude: cfg.LocationLongitude,
+			Timezone:  cfg.Timezone,
+		},
+		Source:            last.Source,
+		GeneratedAt:       now.UTC().Truncate(time.Second),
+		Stale:             true,
+		StaleAfterSeconds: int(cfg.StaleAfter.Seconds()),
+	}
+	if !last.Time.IsZero() {
+		lastReadingAt := last.Time.UTC()
+		metadata.LastReadingAt = &lastReadingAt
+		metadata.AgeSeconds = int(now.Sub(last.Time).Seconds())
+		metadata.Stale = now.Sub(last.Time) > cfg.StaleAfter
+	}
+
+	return metadata
+}
+
+// SendApiV2Response sends the readings, latest first, with their metadata. Unlike the metadata, which changes
+// with every request, the ETag only depends on the readings and whether they are stale, so it is a weak one.
+func SendApiV2Response(c *fiber.Ctx, metadata ApiV2Metadata, readings []Reading, maxAge time.Duration) error {
+	v2Readings := make([]ApiV2Reading, 0, len(readings))
+	for _, r := range slices.Backward(readings) {
+		v2Readings = append(v2Readings, r.ToApiV2Reading())
+	}
+	readingsJson, err := json.Marshal(v2Readings)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(struct {
+		Metadata ApiV2Metadata   `json:"metadata"`
+		Readings json.RawMessage `json:"readings"`
+	}{metadata, readingsJson})
+	if err != nil {
+		return err
+	}
+
+	var lastModified time.Time
+	if metadata.LastReadingAt != nil {
+		lastModified = *metadata.LastReadingAt
+	}
+	if metadata.Stale {
+		readingsJson = append(readingsJson, "stale"...)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return SendCached(c, body, NewWeakETag(readingsJson), lastModified, maxAge)
+}
+
+// ApiV2Routes registers the v2 API endpoints on the router.
+func ApiV2Routes(router fiber.Router, cfg *Config, monnit *Monnit, readings *Readings, history *History) {
+	// Current reading, selected from the sources by SOURCE_MODE
+	router.Get("/temperature", func(c *fiber.Ctx) error {
+		current := readings.Current()
+		var last []Reading
+		if current.GUID != "" {
+			last = append(last, current)
+		}
+		return SendApiV2Response(c, NewApiV2Metadata(cfg, current), last, CacheMaxAge(monnit.NextRefresh()))
+	})
+
+	// Readings of all sources, or the ones in the source query parameter, in the range of the from and
+	// to query parameters, the last seven days by default
+	router.Get("/temperatures", func(c *fiber.Ctx) error {
+		r, err := ParseReadingRange(c, cfg.Location())
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ApiError{Error: err.Error()})
+		}
+		metadata := NewApiV2Metadata(cfg, readings.Current())
+		return SendApiV2Response(c, metadata, FilterSources(history.Between(r.From, r.To), SourcesRequested(c)), CacheMaxAge(monnit.NextRefresh()))
+	})
+}
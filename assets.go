@@ -0,0 +1,96 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+)
+
+//go:embed fonts static themes views conditions.json favicon.png thermometer.png
+var embeddedAssets embed.FS
+
+// Assets holds the fonts, static files, themes, views, condition bands, favicon and icons. Files in the assets
+// directory set with [SetAssetsDir] take precedence over the ones embedded in the binary.
+var Assets fs.FS = embeddedAssets
+
+// SetAssetsDir overlays the directory on the embedded assets, so operators can replace
+// individual files without rebuilding.
+func SetAssetsDir(dir string) {
+	if dir == "" {
+		Assets = embeddedAssets
+		return
+	}
+	Assets = overlayFS{upper: os.DirFS(dir), lower: embeddedAssets}
+}
+
+// overlayFS serves files from upper if they exist there, and from lower otherwise.
+// Directories existing in both list the entries of both.
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Open(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil || !info.IsDir() {
+		return f, err
+	}
+
+	entries, err := o.ReadDir(name)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &overlayDir{File: f, entries: entries}, nil
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, upperErr := fs.ReadDir(o.upper, name)
+	lower, lowerErr := fs.ReadDir(o.lower, name)
+	if upperErr != nil && lowerErr != nil {
+		return nil, upperErr
+	}
+
+	entries := slices.Clone(upper)
+	for _, entry := range lower {
+		if !slices.ContainsFunc(upper, func(e fs.DirEntry) bool { return e.Name() == entry.Name() }) {
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+
+	return entries, nil
+}
+
+// overlayDir is a directory of the upper filesystem, listing the merged entries.
+type overlayDir struct {
+	fs.File
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MAX_CACHE_AGE caps max-age, in case the next refresh is unknown or far away
+const MAX_CACHE_AGE = time.Hour
+
+// NewETag returns a strong ETag for the content.
+func NewETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// NewWeakETag returns a weak ETag for responses that are only semantically the same for the same content,
+// e.g. ones with the time they were sent.
+func NewWeakETag(b []byte) string {
+	return "W/" + NewETag(b)
+}
+
+// CacheMaxAge returns how long a response may be cached, which is until the next expected
+// refresh of the data.
+func CacheMaxAge(nextRefresh time.Time) time.Duration {
+	maxAge := time.Until(nextRefresh)
+	if maxAge < 0 {
+		return 0
+	}
+	return min(maxAge, MAX_CACHE_AGE)
+}
+
+// SetCacheHeaders sets the validators and Cache-Control header of the response.
+func SetCacheHeaders(c *fiber.Ctx, etag string, lastModified time.Time, maxAge time.Duration) {
+	c.Set(fiber.HeaderETag, etag)
+	if !lastModified.IsZero() {
+		c.Set(fiber.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+	c.Set(fiber.HeaderCacheControl, "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+}
+
+// NotModified checks the conditional request headers against the validators of the response.
+// If-None-Match takes precedence over If-Modified-Since, see RFC 9110 section 13.2.2, and uses the weak
+// comparison, which ignores whether ETags are weak.
+func NotModified(c *fiber.Ctx, etag string, lastModified time.Time) bool {
+	if noneMatch := c.Get(fiber.HeaderIfNoneMatch); noneMatch != "" {
+		etag = strings.TrimPrefix(etag, "W/")
+		for _, candidate := range strings.Split(noneMatch, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if modifiedSince := c.Get(fiber.HeaderIfModifiedSince); modifiedSince != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(modifiedSince)
+		if err != nil {
+			return false
+		}
+		// HTTP dates only have second precision
+		return !lastModified.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
+
+// SendCached sends the body with cache headers, or 304 Not Modified if the client's copy is still current.
+func SendCached(c *fiber.Ctx, body []byte, etag string, lastModified time.Time, maxAge time.Duration) error {
+	SetCacheHeaders(c, etag, lastModified, maxAge)
+	if NotModified(c, etag, lastModified) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	return c.Send(body)
+}
+
+// SendCachedJSON sends the value as JSON via [SendCached], with an ETag derived from the JSON.
+func SendCachedJSON(c *fiber.Ctx, v any, lastModified time.Time, maxAge time.Duration) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return SendCached(c, body, NewETag(body), lastModified, maxAge)
+}
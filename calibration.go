@@ -0,0 +1,226 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	CALIBRATION_OFFSET    = "offset"
+	CALIBRATION_LINEAR    = "linear"
+	CALIBRATION_PIECEWISE = "piecewise"
+)
+
+// Calibration corrects the readings of a source from EffectiveFrom until the next calibration of the source.
+//   - offset adds Offset
+//   - linear multiplies by Slope and adds Offset
+//   - piecewise interpolates between Points, and extrapolates beyond them from the first and last two
+type Calibration struct {
+	Source        string             `json:"source"`
+	EffectiveFrom time.Time          `json:"effective_from"`
+	Type          string             `json:"type"`
+	Offset        float64            `json:"offset,omitempty"`
+	Slope         float64            `json:"slope,omitempty"`
+	Points        []CalibrationPoint `json:"points,omitempty"`
+	Note          string             `json:"note,omitempty"`
+}
+
+// CalibrationPoint maps a raw temperature to the actual one, as measured with a reference thermometer.
+type CalibrationPoint struct {
+	Raw    float64 `json:"raw"`
+	Actual float64 `json:"actual"`
+}
+
+// Validate checks the calibration has what its type needs, and sorts piecewise points.
+func (c *Calibration) Validate() error {
+	if c.Source == "" {
+		return errors.New("calibration has no source")
+	}
+	if c.EffectiveFrom.IsZero() {
+		return errors.New("calibration has no effective_from date")
+	}
+	switch c.Type {
+	case CALIBRATION_OFFSET:
+	case CALIBRATION_LINEAR:
+		if c.Slope == 0 {
+			return errors.New("linear calibration has no slope")
+		}
+	case CALIBRATION_PIECEWISE:
+		if len(c.Points) < 2 {
+			return errors.New("piecewise calibration needs at least two points")
+		}
+		slices.SortFunc(c.Points, func(a, b CalibrationPoint) int {
+			return cmp.Compare(a.Raw, b.Raw)
+		})
+		for i := 1; i < len(c.Points); i++ {
+			if c.Points[i].Raw == c.Points[i-1].Raw {
+				return fmt.Errorf("piecewise calibration has two points for %.2f", c.Points[i].Raw)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown calibration type %q, expected offset, linear or piecewise", c.Type)
+	}
+	return nil
+}
+
+// Apply returns the corrected temperature, rounded to hundredths.
+func (c Calibration) Apply(raw float64) float64 {
+	var corrected float64
+	switch c.Type {
+	case CALIBRATION_OFFSET:
+		corrected = raw + c.Offset
+	case CALIBRATION_LINEAR:
+		corrected = raw*c.Slope + c.Offset
+	case CALIBRATION_PIECEWISE:
+		i, _ := slices.BinarySearchFunc(c.Points, raw, func(p CalibrationPoint, t float64) int {
+			return cmp.Compare(p.Raw, t)
+		})
+		// Segment around raw, or the first or last one outside the points
+		i = min(max(i, 1), len(c.Points)-1)
+		a, b := c.Points[i-1], c.Points[i]
+		corrected = a.Actual + (raw-a.Raw)*(b.Actual-a.Actual)/(b.Raw-a.Raw)
+	default:
+		corrected = raw
+	}
+	return math.Round(corrected*100) / 100
+}
+
+// Calibrations holds the calibrations of all sources, sorted by date, persisted to a JSON file.
+type Calibrations struct {
+	sync.RWMutex
+	filename     string
+	calibrations []Calibration
+}
+
+// LoadCalibrations loads the calibrations from the file, if it exists.
+func LoadCalibrations(filename string) (*Calibrations, error) {
+	c := Calibrations{filename: filename}
+
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return &c, nil
+	}
+	if err != nil {
+		return &c, err
+	}
+	if err = json.Unmarshal(b, &c.calibrations); err != nil {
+		return &c, err
+	}
+	for i := range c.calibrations {
+		if err = c.calibrations[i].Validate(); err != nil {
+			return &c, err
+		}
+	}
+	slices.SortStableFunc(c.calibrations, compareCalibrations)
+
+	return &c, nil
+}
+
+func compareCalibrations(a, b Calibration) int {
+	return a.EffectiveFrom.Compare(b.EffectiveFrom)
+}
+
+// All returns a copy of the calibrations, oldest first.
+func (c *Calibrations) All() []Calibration {
+	c.RLock()
+	defer c.RUnlock()
+
+	return slices.Clone(c.calibrations)
+}
+
+// Add validates the calibration and saves it with the others.
+func (c *Calibrations) Add(calibration Calibration) error {
+	if err := calibration.Validate(); err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	calibrations := append(slices.Clone(c.calibrations), calibration)
+	slices.SortStableFunc(calibrations, compareCalibrations)
+	b, err := json.MarshalIndent(calibrations, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(c.filename+".tmp", b, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(c.filename+".tmp", c.filename); err != nil {
+		return err
+	}
+	c.calibrations = calibrations
+
+	return nil
+}
+
+// Correct applies the calibration of the reading's source in effect at its time. The raw temperature is kept
+// in RawTemperature, which is nil if no calibration applies.
+func (c *Calibrations) Correct(r Reading) Reading {
+	raw := r.Temperature
+	if r.RawTemperature != nil {
+		raw = *r.RawTemperature
+	}
+	r.Temperature = raw
+	r.RawTemperature = nil
+
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, calibration := range slices.Backward(c.calibrations) {
+		if calibration.Source == r.Source && !calibration.EffectiveFrom.After(r.Time) {
+			if !math.IsNaN(raw) {
+				r.Temperature = calibration.Apply(raw)
+				r.RawTemperature = &raw
+			}
+			break
+		}
+	}
+	return r
+}
+
+// REFERENCE_MAX_GAP is how far apart a reference reading and the reading of the sensor it is compared to may be
+const REFERENCE_MAX_GAP = 30 * time.Minute
+
+// ReferenceReading is a temperature measured with a reference thermometer, to calibrate a source against.
+type ReferenceReading struct {
+	Source      string    `json:"source"`
+	Temperature float64   `json:"temperature"`
+	Time        time.Time `json:"time"`
+}
+
+// NewReferenceCalibration creates an offset calibration, effective from the time of the reference reading,
+// that corrects the raw reading of the source closest to it to the reference temperature.
+func NewReferenceCalibration(history *History, reference ReferenceReading) (Calibration, error) {
+	var closest *Reading
+	for _, r := range history.Between(reference.Time.Add(-REFERENCE_MAX_GAP), reference.Time.Add(REFERENCE_MAX_GAP)) {
+		if r.Source != reference.Source || r.IsRejected() {
+			continue
+		}
+		if closest == nil || r.Time.Sub(reference.Time).Abs() < closest.Time.Sub(reference.Time).Abs() {
+			closest = &r
+		}
+	}
+	if closest == nil {
+		return Calibration{}, fmt.Errorf("no reading of %s within %s of the reference reading", reference.Source, REFERENCE_MAX_GAP)
+	}
+
+	raw := closest.Temperature
+	if closest.RawTemperature != nil {
+		raw = *closest.RawTemperature
+	}
+	return Calibration{
+		Source:        reference.Source,
+		EffectiveFrom: reference.Time.UTC(),
+		Type:          CALIBRATION_OFFSET,
+		Offset:        math.Round((reference.Temperature-raw)*100) / 100,
+		Note:          fmt.Sprintf("Reference reading of %.2f°C, sensor read %.2f°C at %s", reference.Temperature, raw, closest.Time.Format(time.RFC3339)),
+	}, nil
+}
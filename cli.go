@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const usage = `Usage: bude-seapool-temperature [command]
+
+Without command, the service is started.
+
+Commands:
+  state dump  Print the state as JSON
+  state edit  Edit the state in $EDITOR, stop the service first as it would overwrite the changes
+`
+
+// RunCommand runs the command given on the command line instead of the service, and returns the exit code.
+func RunCommand(cfg *Config, args []string) int {
+	var err error
+	switch {
+	case len(args) == 2 && args[0] == "state" && args[1] == "dump":
+		err = dumpState(cfg.StateFile, os.Stdout)
+	case len(args) == 2 && args[0] == "state" && args[1] == "edit":
+		err = editState(cfg.StateFile)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// peekState reads the state like [LoadState], from the state file, its backup or the legacy state file, but leaves
+// restoring a corrupt state file to the service. It returns the file the state was read from, which is empty if
+// there is no state yet.
+func peekState(filename string) (*State, string, error) {
+	var corrupt error
+	for _, name := range []string{filename, filename + ".bak", filepath.Join(filepath.Dir(filename), LEGACY_STATE_FILE)} {
+		state, err := readState(name)
+		switch {
+		case err == nil:
+			return state, name, nil
+		case errors.Is(err, ErrStateVersion):
+			return nil, name, err
+		case !errors.Is(err, os.ErrNotExist) && corrupt == nil:
+			corrupt = fmt.Errorf("%s is corrupt: %w", name, err)
+		}
+	}
+	if corrupt != nil {
+		return nil, "", corrupt
+	}
+	return &State{}, "", nil
+}
+
+// dumpState writes the state, migrated to the current version, as indented JSON.
+func dumpState(filename string, w io.Writer) error {
+	state, from, err := peekState(filename)
+	if err != nil {
+		return err
+	}
+	if from != "" && from != filename {
+		fmt.Fprintf(os.Stderr, "state read from %s\n", from)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(state)
+}
+
+// editState opens the state in the editor set by VISUAL or EDITOR, and saves it if it was changed and is valid.
+// A corrupt state file isn't edited, as saving would replace its backup.
+func editState(filename string) error {
+	if _, err := readState(filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s is corrupt, start the service to restore the backup first: %w", filename, err)
+	}
+
+	f, err := os.CreateTemp("", "state-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = dumpState(filename, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	original, err := os.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+
+	// Through the shell, as editors are often set with arguments, e.g. "code --wait"
+	editor := cmp.Or(os.Getenv("VISUAL"), os.Getenv("EDITOR"), "vi")
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", f.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("editor failed, nothing saved: %w", err)
+	}
+
+	edited, err := os.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, original) {
+		fmt.Println("state unchanged")
+		return nil
+	}
+
+	var state State
+	dec := json.NewDecoder(bytes.NewReader(edited))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&state); err != nil {
+		return fmt.Errorf("invalid state, nothing saved: %w", err)
+	}
+	if err = SaveState(filename, &state); err != nil {
+		return err
+	}
+	fmt.Printf("saved state to %s, the previous state is in %s.bak\n", filename, filename)
+	return nil
+}
@@ -0,0 +1,206 @@
+// Package client is a Go client for the Bude Seapool Temperature API.
+//
+//	c := client.New("https://spt.tsak.dev", client.WithAPIKey(os.Getenv("SPT_API_KEY")))
+//	reading, err := c.Temperature(ctx)
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_TIMEOUT = 10 * time.Second
+	API_KEY_HEADER  = "X-API-Key"
+)
+
+// Reading is a temperature reading of the sensor.
+type Reading struct {
+	// Temperature in degrees Celsius
+	Temperature float64 `json:"temperature"`
+	// DateTime is when the sensor took the reading
+	DateTime time.Time `json:"datetime"`
+}
+
+// Condition is a band of temperatures with the same swimming conditions.
+type Condition struct {
+	Name string `json:"name"`
+	// Min and Max are nil for the lowest and highest band
+	Min     *float64 `json:"min"`
+	Max     *float64 `json:"max"`
+	Color   string   `json:"color"`
+	Icon    string   `json:"icon"`
+	Advice  string   `json:"advice"`
+	Wetsuit bool     `json:"wetsuit"`
+}
+
+// Conditions is the swimming condition of the latest reading, and all condition bands.
+type Conditions struct {
+	Temperature float64   `json:"temperature"`
+	DateTime    time.Time `json:"datetime"`
+	// Condition is nil if the temperature is below all bands
+	Condition *Condition  `json:"condition"`
+	Bands     []Condition `json:"bands"`
+}
+
+// Error is returned for responses other than 200 OK.
+type Error struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is how long to wait before retrying when rate limited
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("spt api: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("spt api: %d %s", e.StatusCode, e.Message)
+}
+
+// IsRateLimited reports whether err is an [Error] for an exceeded rate limit.
+func IsRateLimited(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusTooManyRequests
+}
+
+// Client calls the API of a Bude Seapool Temperature service.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// Option configures a [Client].
+type Option func(*Client)
+
+// WithAPIKey sends the API key with every request, for the higher rate limit of API keys.
+func WithAPIKey(apiKey string) Option {
+	return func(c *Client) {
+		c.apiKey = apiKey
+	}
+}
+
+// WithHTTPClient uses the HTTP client instead of one with [DEFAULT_TIMEOUT].
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout sets the timeout of each request.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.httpClient = &http.Client{Timeout: timeout}
+	}
+}
+
+// New creates a client for the service at baseURL, e.g. https://spt.tsak.dev.
+func New(baseURL string, opts ...Option) *Client {
+	c := Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: DEFAULT_TIMEOUT},
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return &c
+}
+
+// Temperature returns the latest reading.
+func (c *Client) Temperature(ctx context.Context) (*Reading, error) {
+	var reading Reading
+	if err := c.get(ctx, "/api/v1/temperature", &reading); err != nil {
+		return nil, err
+	}
+	return &reading, nil
+}
+
+// Temperatures returns the readings of the last seven days, latest first.
+func (c *Client) Temperatures(ctx context.Context) ([]Reading, error) {
+	var readings []Reading
+	if err := c.get(ctx, "/api/v1/temperatures", &readings); err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// Conditions returns the swimming condition of the latest reading.
+func (c *Client) Conditions(ctx context.Context) (*Conditions, error) {
+	var conditions Conditions
+	if err := c.get(ctx, "/api/v1/conditions", &conditions); err != nil {
+		return nil, err
+	}
+	return &conditions, nil
+}
+
+// TemperaturesBetween returns the readings from up to but excluding to, latest first.
+func (c *Client) TemperaturesBetween(ctx context.Context, from, to time.Time) ([]Reading, error) {
+	q := url.Values{}
+	q.Set("from", from.Format(time.RFC3339))
+	q.Set("to", to.Format(time.RFC3339))
+
+	var readings []Reading
+	if err := c.get(ctx, "/api/v1/temperatures?"+q.Encode(), &readings); err != nil {
+		return nil, err
+	}
+	return readings, nil
+}
+
+// get requests the path and decodes the JSON response into v.
+func (c *Client) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set(API_KEY_HEADER, c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("spt api: decoding %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// newError reads the error message of the response, if any.
+func newError(resp *http.Response) *Error {
+	e := Error{StatusCode: resp.StatusCode}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	if b, err := io.ReadAll(io.LimitReader(resp.Body, 4096)); err == nil {
+		if json.Unmarshal(b, &body) == nil {
+			e.Message = body.Error
+		} else {
+			e.Message = strings.TrimSpace(string(b))
+		}
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	return &e
+}
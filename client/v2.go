@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// Response is a response of the v2 API, readings with metadata describing them.
+type Response struct {
+	Metadata Metadata    `json:"metadata"`
+	Readings []ReadingV2 `json:"readings"`
+}
+
+// Metadata describes the sensor and how current its readings are.
+type Metadata struct {
+	Sensor struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"sensor"`
+	Unit     string `json:"unit"`
+	Location struct {
+		Name      string  `json:"name"`
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		Timezone  string  `json:"timezone"`
+	} `json:"location"`
+	Source            string     `json:"source"`
+	GeneratedAt       time.Time  `json:"generated_at"`
+	LastReadingAt     *time.Time `json:"last_reading_at"`
+	AgeSeconds        int        `json:"age_seconds"`
+	Stale             bool       `json:"stale"`
+	StaleAfterSeconds int        `json:"stale_after_seconds"`
+}
+
+// ReadingV2 is a reading of the v2 API.
+type ReadingV2 struct {
+	Id    string  `json:"id"`
+	Value float64 `json:"value"`
+	// RawValue is the value before calibration, nil if the source isn't calibrated
+	RawValue  *float64  `json:"raw_value"`
+	Unit      string    `json:"unit"`
+	Timestamp time.Time `json:"timestamp"`
+	// Source is "monnit" for the sensor, or the name of another device
+	Source string `json:"source"`
+	// Health is nil if the sensor didn't report any
+	Health *Health `json:"health"`
+	// Measurements besides the temperature, if the sensor measures more
+	Measurements []Measurement `json:"measurements"`
+	// Rejected is the reason the reading failed validation, empty for valid readings. The values of
+	// rejected readings aren't temperatures of the pool, and may be zero.
+	Rejected string `json:"rejected"`
+}
+
+// IsRejected checks if the reading failed validation.
+func (r ReadingV2) IsRejected() bool {
+	return r.Rejected != ""
+}
+
+// Measurement is a quantity measured besides the temperature, e.g. the humidity.
+type Measurement struct {
+	Name  string  `json:"name"`
+	Label string  `json:"label"`
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// Health is the state of the sensor when it sent the reading. Fields are nil if not reported.
+type Health struct {
+	Battery        *int     `json:"battery"`
+	SignalStrength *int     `json:"signal_strength"`
+	Voltage        *float64 `json:"voltage"`
+}
+
+// Latest returns the latest reading with metadata. Readings is empty if the service has no reading yet.
+func (c *Client) Latest(ctx context.Context) (*Response, error) {
+	var resp Response
+	if err := c.get(ctx, "/api/v2/temperature", &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Readings returns the readings from up to but excluding to, latest first, with metadata.
+// Rejected readings are included, see [ReadingV2.IsRejected].
+// Zero times use the defaults of the API, which are seven days ago and now.
+func (c *Client) Readings(ctx context.Context, from, to time.Time) (*Response, error) {
+	q := url.Values{}
+	if !from.IsZero() {
+		q.Set("from", from.Format(time.RFC3339))
+	}
+	if !to.IsZero() {
+		q.Set("to", to.Format(time.RFC3339))
+	}
+
+	var resp Response
+	if err := c.get(ctx, "/api/v2/temperatures?"+q.Encode(), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
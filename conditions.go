@@ -0,0 +1,82 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+)
+
+// ConditionBand is a range of temperatures with the same swimming conditions, from Min up to the Min of
+// the next band. The band without Min covers everything below the others.
+type ConditionBand struct {
+	Name    string   `json:"name"`
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+	Color   Color    `json:"color"`
+	Icon    string   `json:"icon"`
+	Advice  string   `json:"advice"`
+	Wetsuit bool     `json:"wetsuit"`
+}
+
+// Conditions holds the condition bands, sorted by temperature.
+type Conditions []ConditionBand
+
+// LoadConditions loads the condition bands from the file, or from conditions.json in the [Assets] if no file is given.
+// The Max of each band is set from the Min of the next one.
+func LoadConditions(file string) (Conditions, error) {
+	var b []byte
+	var err error
+	if file == "" {
+		b, err = fs.ReadFile(Assets, "conditions.json")
+	} else {
+		b, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var conditions Conditions
+	if err = json.Unmarshal(b, &conditions); err != nil {
+		return nil, err
+	}
+	if len(conditions) == 0 {
+		return nil, errors.New("no condition bands")
+	}
+
+	slices.SortStableFunc(conditions, func(a, b ConditionBand) int {
+		switch {
+		case a.Min == nil && b.Min == nil:
+			return 0
+		case a.Min == nil:
+			return -1
+		case b.Min == nil:
+			return 1
+		}
+		return cmp.Compare(*a.Min, *b.Min)
+	})
+	for i := range conditions {
+		if i > 0 && conditions[i].Min == nil {
+			return nil, fmt.Errorf("only one condition band may have no min, %q and %q have none", conditions[0].Name, conditions[i].Name)
+		}
+		conditions[i].Max = nil
+		if i+1 < len(conditions) {
+			conditions[i].Max = conditions[i+1].Min
+		}
+	}
+
+	return conditions, nil
+}
+
+// For returns the band the temperature falls into, or nil if it is below all bands.
+func (c Conditions) For(temperature float64) *ConditionBand {
+	for i := len(c) - 1; i >= 0; i-- {
+		if c[i].Min == nil || temperature >= *c[i].Min {
+			return &c[i]
+		}
+	}
+	return nil
+}
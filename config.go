@@ -21,20 +21,33 @@ type Config struct {
 	// Monnit API URL
 	ApiUrl string `env:"MONNIT_API_URL"`
 
-	// Monnit refresh interval
+	// Monnit refresh interval, 0 disables polling when readings are pushed by the Monnit webhook
 	RefreshInterval time.Duration `env:"MONNIT_REFRESH_INTERVAL" envDefault:"10m"`
 
+	// Basic authentication of the iMonnit webhook posting to /ingest/monnit, which is disabled without password
+	MonnitWebhookUsername string `env:"MONNIT_WEBHOOK_USERNAME" envDefault:"monnit"`
+	MonnitWebhookPassword string `env:"MONNIT_WEBHOOK_PASSWORD"`
+
 	// Image width
 	ImageWidth int `env:"IMAGE_WIDTH" envDefault:"2560"`
 
 	// Image height
 	ImageHeight int `env:"IMAGE_HEIGHT" envDefault:"1440"`
 
+	// Maximum number of pixels of images requested in custom sizes
+	ImageMaxArea int `env:"IMAGE_MAX_AREA" envDefault:"8294400"`
+
+	// Show today's high and low, yesterday's average and last year's average on the display image
+	ImageStats bool `env:"IMAGE_STATS" envDefault:"false"`
+
+	// Number of custom image sizes kept rendered
+	ImageCacheSize int `env:"IMAGE_CACHE_SIZE" envDefault:"32"`
+
 	// Address the webserver will listen on
 	Address string `env:"ADDRESS"`
 
-	// State file
-	StateFile string `env:"STATE_FILE" envDefault:"state.gob"`
+	// State file, a state.gob of earlier releases next to it is migrated
+	StateFile string `env:"STATE_FILE" envDefault:"state.json"`
 
 	// State autosave interval
 	StateAutosaveInterval time.Duration `env:"STATE_AUTOSAVE_INTERVAL" envDefault:"1m"`
@@ -44,6 +57,104 @@ type Config struct {
 
 	// If a maintenance message is set, we display that instead of the temperature
 	MaintenanceMessage string `env:"MAINTENANCE_MESSAGE"`
+
+	// Default theme of the images, one of the themes/<name>.json files
+	Theme string `env:"THEME" envDefault:"light"`
+
+	// Directory with fonts, views or favicon.png replacing the ones embedded in the binary
+	AssetsDir string `env:"ASSETS_DIR"`
+
+	// Public URL of the service, e.g. https://spt.tsak.dev, used for the feeds, which are disabled if not set
+	PublicUrl string `env:"PUBLIC_URL"`
+
+	// Origins allowed to call the API from browsers
+	CorsAllowOrigins string `env:"CORS_ALLOW_ORIGINS" envDefault:"*"`
+
+	// Header with the client IP set by a reverse proxy, e.g. X-Forwarded-For
+	ProxyHeader string `env:"PROXY_HEADER"`
+
+	// API requests per minute and burst per client IP
+	RateLimit      int `env:"RATE_LIMIT" envDefault:"60"`
+	RateLimitBurst int `env:"RATE_LIMIT_BURST" envDefault:"30"`
+
+	// API keys as name:key pairs, e.g. "council:s3cret,harbour:t0ps3cret"
+	ApiKeys map[string]string `env:"API_KEYS"`
+
+	// API requests per minute and burst per API key
+	ApiKeyRateLimit      int `env:"API_KEY_RATE_LIMIT" envDefault:"600"`
+	ApiKeyRateLimitBurst int `env:"API_KEY_RATE_LIMIT_BURST" envDefault:"120"`
+
+	// Bearer token for the admin endpoints, which are disabled if not set
+	AdminToken string `env:"ADMIN_TOKEN"`
+
+	// File keeping all readings, as Monnit only returns the last seven days
+	HistoryFile string `env:"HISTORY_FILE" envDefault:"history.ndjson"`
+
+	// Time zone of the pool, used for local times in exports and date filters
+	Timezone string `env:"TIMEZONE" envDefault:"Europe/London"`
+	location *time.Location
+
+	// Sensor and location described in the metadata of the v2 API
+	SensorName        string  `env:"SENSOR_NAME" envDefault:"Pool temperature"`
+	LocationName      string  `env:"LOCATION_NAME" envDefault:"Bude Sea Pool"`
+	LocationLatitude  float64 `env:"LOCATION_LATITUDE" envDefault:"50.8319"`
+	LocationLongitude float64 `env:"LOCATION_LONGITUDE" envDefault:"-4.5552"`
+
+	// JSON file with the swimming condition bands, defaults to conditions.json in the assets
+	ConditionsFile string `env:"CONDITIONS_FILE"`
+
+	// Readings older than this are reported as stale
+	StaleAfter time.Duration `env:"STALE_AFTER" envDefault:"1h"`
+
+	// MQTT broker readings are published to, e.g. tcp://localhost:1883, disabled if not set
+	MqttBroker   string `env:"MQTT_BROKER"`
+	MqttUsername string `env:"MQTT_USERNAME"`
+	MqttPassword string `env:"MQTT_PASSWORD"`
+	MqttClientId string `env:"MQTT_CLIENT_ID" envDefault:"bude-seapool-temperature"`
+
+	// Base topic of the readings, published to <topic>/state
+	MqttTopic string `env:"MQTT_TOPIC" envDefault:"bude-seapool-temperature"`
+
+	// Prefix of the Home Assistant discovery topics
+	MqttDiscoveryPrefix string `env:"MQTT_DISCOVERY_PREFIX" envDefault:"homeassistant"`
+
+	// JSON file with the webhook subscriptions, webhooks are disabled if not set
+	WebhooksFile string `env:"WEBHOOKS_FILE"`
+
+	// File logging the webhook deliveries
+	WebhookLogFile string `env:"WEBHOOK_LOG_FILE" envDefault:"webhooks.json"`
+
+	// Tokens of devices posting readings as name:token pairs, e.g. "handheld:s3cret,esp32:t0ps3cret"
+	DeviceTokens map[string]string `env:"DEVICE_TOKENS"`
+
+	// How the current reading is selected from the sources, prefer or latest
+	SourceMode string `env:"SOURCE_MODE" envDefault:"prefer"`
+
+	// Sources in order of preference, used while their latest reading isn't stale
+	SourcePriority []string `env:"SOURCE_PRIORITY" envDefault:"monnit"`
+
+	// Readings outside this range in °C are rejected
+	ValidationMinTemperature float64 `env:"VALIDATION_MIN_TEMPERATURE" envDefault:"-2"`
+	ValidationMaxTemperature float64 `env:"VALIDATION_MAX_TEMPERATURE" envDefault:"35"`
+
+	// Readings changing faster than this many °C per hour are rejected, 0 disables the check
+	ValidationMaxRate float64 `env:"VALIDATION_MAX_RATE" envDefault:"6"`
+
+	// Readings further than this many °C from the median of the readings in the window before are rejected,
+	// 0 disables the check
+	ValidationMaxDeviation float64       `env:"VALIDATION_MAX_DEVIATION" envDefault:"2.5"`
+	ValidationMedianWindow time.Duration `env:"VALIDATION_MEDIAN_WINDOW" envDefault:"2h"`
+
+	// JSON file with the calibrations of the sensors, written by the admin API
+	CalibrationsFile string `env:"CALIBRATIONS_FILE" envDefault:"calibrations.json"`
+
+	// Parts of the user agents of signage displays, told apart from browsers in the request analytics
+	SignageUserAgents []string `env:"SIGNAGE_USER_AGENTS" envDefault:"BrightSign,Tizen,Web0S,SMART-TV,CrKey,Raspbian"`
+}
+
+// Location returns the time zone set by TIMEZONE.
+func (c Config) Location() *time.Location {
+	return c.location
 }
 
 func (c Config) LogValue() slog.Value {
@@ -52,12 +163,53 @@ func (c Config) LogValue() slog.Value {
 		slog.String("api_key_id", c.ApiKeyId),
 		slog.String("api_url", c.ApiUrl),
 		slog.Duration("refresh_interval", c.RefreshInterval),
+		slog.String("monnit_webhook_username", c.MonnitWebhookUsername),
+		slog.Bool("monnit_webhook_enabled", c.MonnitWebhookPassword != ""),
 		slog.Int("image_width", c.ImageWidth),
 		slog.Int("image_height", c.ImageHeight),
+		slog.Int("image_max_area", c.ImageMaxArea),
+		slog.Int("image_cache_size", c.ImageCacheSize),
+		slog.Bool("image_stats", c.ImageStats),
 		slog.String("address", c.Address),
 		slog.String("state_file", c.StateFile),
 		slog.Duration("state_autosave_interval", c.StateAutosaveInterval),
 		slog.String("maintenance_message", c.MaintenanceMessage),
+		slog.String("theme", c.Theme),
+		slog.String("assets_dir", c.AssetsDir),
+		slog.String("public_url", c.PublicUrl),
+		slog.String("cors_allow_origins", c.CorsAllowOrigins),
+		slog.String("proxy_header", c.ProxyHeader),
+		slog.Int("rate_limit", c.RateLimit),
+		slog.Int("rate_limit_burst", c.RateLimitBurst),
+		slog.Int("api_keys", len(c.ApiKeys)),
+		slog.Int("api_key_rate_limit", c.ApiKeyRateLimit),
+		slog.Int("api_key_rate_limit_burst", c.ApiKeyRateLimitBurst),
+		slog.Bool("admin_enabled", c.AdminToken != ""),
+		slog.String("history_file", c.HistoryFile),
+		slog.String("timezone", c.Timezone),
+		slog.String("sensor_name", c.SensorName),
+		slog.String("location_name", c.LocationName),
+		slog.Float64("location_latitude", c.LocationLatitude),
+		slog.Float64("location_longitude", c.LocationLongitude),
+		slog.String("conditions_file", c.ConditionsFile),
+		slog.Duration("stale_after", c.StaleAfter),
+		slog.String("mqtt_broker", c.MqttBroker),
+		slog.String("mqtt_username", c.MqttUsername),
+		slog.String("mqtt_client_id", c.MqttClientId),
+		slog.String("mqtt_topic", c.MqttTopic),
+		slog.String("mqtt_discovery_prefix", c.MqttDiscoveryPrefix),
+		slog.String("webhooks_file", c.WebhooksFile),
+		slog.String("webhook_log_file", c.WebhookLogFile),
+		slog.Int("device_tokens", len(c.DeviceTokens)),
+		slog.String("source_mode", c.SourceMode),
+		slog.Any("source_priority", c.SourcePriority),
+		slog.Float64("validation_min_temperature", c.ValidationMinTemperature),
+		slog.Float64("validation_max_temperature", c.ValidationMaxTemperature),
+		slog.Float64("validation_max_rate", c.ValidationMaxRate),
+		slog.Float64("validation_max_deviation", c.ValidationMaxDeviation),
+		slog.Duration("validation_median_window", c.ValidationMedianWindow),
+		slog.String("calibrations_file", c.CalibrationsFile),
+		slog.Any("signage_user_agents", c.SignageUserAgents),
 	)
 }
 
@@ -76,5 +228,22 @@ func LoadConfig() *Config {
 		slog.Error("unable to parse config", "error", err)
 		os.Exit(1)
 	}
+
+	location, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		slog.Error("unknown timezone", "timezone", cfg.Timezone, "error", err)
+		os.Exit(1)
+	}
+	cfg.location = location
+
+	if cfg.SourceMode != SOURCE_MODE_PREFER && cfg.SourceMode != SOURCE_MODE_LATEST {
+		slog.Error("unknown source mode", "source_mode", cfg.SourceMode)
+		os.Exit(1)
+	}
+	if err := ValidateDeviceNames(cfg.DeviceTokens); err != nil {
+		slog.Error("invalid device tokens", "error", err)
+		os.Exit(1)
+	}
+
 	return &cfg
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+)
+
+const (
+	EVENT_READING     = "reading"
+	EVENT_MAINTENANCE = "maintenance"
+)
+
+// Event is pushed to live update subscribers whenever something shown on the images changes.
+type Event struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// MaintenanceEvent is the payload of a maintenance event, an empty message means maintenance has ended.
+type MaintenanceEvent struct {
+	Message string `json:"message"`
+}
+
+// NewReadingEvent creates the event for a new reading.
+func NewReadingEvent(last *SensorDataMessage) Event {
+	msg := last.ToApiMessage()
+	// Pointer, as ApiMessage fields only marshal correctly when addressable
+	return Event{Type: EVENT_READING, Data: &msg}
+}
+
+// NewMaintenanceEvent creates the event for a changed maintenance message.
+func NewMaintenanceEvent(msg string) Event {
+	return Event{Type: EVENT_MAINTENANCE, Data: MaintenanceEvent{Message: msg}}
+}
+
+// EventBroker fans out published events to all subscribers.
+type EventBroker struct {
+	sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe returns a channel that receives all events published from now on.
+// Call [EventBroker.Unsubscribe] once done with it.
+func (eb *EventBroker) Subscribe() chan Event {
+	eb.Lock()
+	defer eb.Unlock()
+
+	ch := make(chan Event, 8)
+	eb.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// Unsubscribe removes and closes a channel returned by [EventBroker.Subscribe].
+func (eb *EventBroker) Unsubscribe(ch chan Event) {
+	eb.Lock()
+	defer eb.Unlock()
+
+	if _, ok := eb.subscribers[ch]; ok {
+		delete(eb.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish sends the event to all subscribers. Subscribers that are not keeping up miss the event
+// rather than blocking everybody else.
+func (eb *EventBroker) Publish(e Event) {
+	eb.Lock()
+	defer eb.Unlock()
+
+	for ch := range eb.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DEFAULT_RANGE is how far back readings go if no start of the range is requested
+const DEFAULT_RANGE = 7 * 24 * time.Hour
+
+const (
+	LOCAL_TIME_FORMAT = "2006-01-02 15:04:05"
+	DATE_ONLY_FORMAT  = "2006-01-02"
+)
+
+var ErrInvalidRange = errors.New("invalid date range")
+
+// ReadingRange is the range of readings requested with the from and to query parameters.
+type ReadingRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// ParseReadingRange reads the from and to query parameters, which are either RFC 3339 times or dates
+// in the given location. The range includes the whole day of a to date. A missing from defaults to
+// seven days before to, or before now if to is missing as well, which means up to now.
+func ParseReadingRange(c *fiber.Ctx, location *time.Location) (ReadingRange, error) {
+	var r ReadingRange
+	var err error
+
+	end := time.Now()
+	if to := c.Query("to"); to != "" {
+		if r.To, err = parseRangeTime(to, location, true); err != nil {
+			return r, err
+		}
+		end = r.To
+	}
+	if from := c.Query("from"); from != "" {
+		if r.From, err = parseRangeTime(from, location, false); err != nil {
+			return r, err
+		}
+	} else {
+		r.From = end.Add(-DEFAULT_RANGE)
+	}
+	if !r.To.IsZero() && r.To.Before(r.From) {
+		return r, fmt.Errorf("%w: to is before from", ErrInvalidRange)
+	}
+
+	return r, nil
+}
+
+func parseRangeTime(value string, location *time.Location, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(DATE_ONLY_FORMAT, value, location)
+	if err != nil {
+		return t, fmt.Errorf("%w: %q is neither a date nor an RFC 3339 time", ErrInvalidRange, value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// RangeRequested reports whether any of the range was requested explicitly.
+func RangeRequested(c *fiber.Ctx) bool {
+	return c.Query("from") != "" || c.Query("to") != ""
+}
+
+// SourcesRequested returns the sources in the comma separated source query parameter, or none for all sources.
+func SourcesRequested(c *fiber.Ctx) []string {
+	var sources []string
+	for _, s := range strings.Split(c.Query("source"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			sources = append(sources, s)
+		}
+	}
+	return sources
+}
+
+// ApiResponseFromReadings converts readings, oldest first, to an ApiResponse with the latest first.
+// Rejected readings are left out.
+func ApiResponseFromReadings(readings []Reading) ApiResponse {
+	apiMessages := make(ApiResponse, 0, len(readings))
+	for _, r := range slices.Backward(readings) {
+		if !r.IsRejected() {
+			apiMessages = append(apiMessages, r.ToApiMessage())
+		}
+	}
+	return apiMessages
+}
+
+// ExportReading is a reading as exported to CSV, NDJSON and spreadsheets.
+type ExportReading struct {
+	Temperature    float64   `json:"temperature"`
+	RawTemperature *float64  `json:"raw_temperature,omitempty"`
+	TimeLocal      time.Time `json:"time_local"`
+	TimeUTC        time.Time `json:"time_utc"`
+	Battery        *int      `json:"battery,omitempty"`
+	SignalStrength *int      `json:"signal_strength,omitempty"`
+	Source         *string   `json:"source,omitempty"`
+}
+
+// exportColumns holds the columns of the export, including the optional ones requested.
+type exportColumns struct {
+	raw, battery, signal, source bool
+	names                        []string
+}
+
+// newExportColumns returns the columns of the export, including the raw temperature before calibration, battery,
+// signal strength and source if requested with the include query parameter, e.g. include=battery,signal.
+func newExportColumns(include string) (exportColumns, error) {
+	var columns exportColumns
+	for _, column := range strings.Split(include, ",") {
+		switch strings.TrimSpace(column) {
+		case "":
+		case "raw":
+			columns.raw = true
+		case "battery":
+			columns.battery = true
+		case "signal":
+			columns.signal = true
+		case "source":
+			columns.source = true
+		default:
+			return columns, fmt.Errorf("unknown column %q, expected raw, battery, signal or source", column)
+		}
+	}
+
+	columns.names = []string{"temperature"}
+	if columns.raw {
+		columns.names = append(columns.names, "raw_temperature")
+	}
+	columns.names = append(columns.names, "time_local", "time_utc")
+	if columns.battery {
+		columns.names = append(columns.names, "battery")
+	}
+	if columns.signal {
+		columns.names = append(columns.names, "signal_strength")
+	}
+	if columns.source {
+		columns.names = append(columns.names, "source")
+	}
+	return columns, nil
+}
+
+// ExportHandler streams the accepted readings in the requested range as CSV, NDJSON or XLSX, oldest first,
+// depending on the format route parameter.
+func ExportHandler(history *History, location *time.Location) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		r, err := ParseReadingRange(c, location)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ApiError{Error: err.Error()})
+		}
+		columns, err := newExportColumns(c.Query("include"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ApiError{Error: err.Error()})
+		}
+
+		// Logged while streaming, after the handler returned and Fiber reused the request
+		format := strings.Clone(c.Params("format"))
+		var write func(w io.Writer, rows iter.Seq[ExportReading]) error
+		switch format {
+		case "csv":
+			c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+			write = func(w io.Writer, rows iter.Seq[ExportReading]) error {
+				return writeCsv(w, columns.names, rows)
+			}
+		case "ndjson":
+			c.Set(fiber.HeaderContentType, "application/x-ndjson")
+			write = writeNdjson
+		case "xlsx":
+			c.Set(fiber.HeaderContentType, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+			write = func(w io.Writer, rows iter.Seq[ExportReading]) error {
+				return writeXlsx(w, columns.names, rows)
+			}
+		default:
+			return fiber.ErrNotFound
+		}
+		c.Attachment(exportFilename(r, location) + "." + format)
+
+		// The readings are shared with the history, only the current row is built while streaming
+		readings := FilterSources(history.Between(r.From, r.To), SourcesRequested(c))
+		rows := func(yield func(ExportReading) bool) {
+			for _, reading := range readings {
+				if reading.IsRejected() {
+					continue
+				}
+				row := ExportReading{
+					Temperature: reading.Temperature,
+					TimeLocal:   reading.Time.In(location),
+					TimeUTC:     reading.Time.UTC(),
+				}
+				if columns.raw {
+					// Without calibration, the raw temperature is the same
+					raw := reading.Temperature
+					if reading.RawTemperature != nil {
+						raw = *reading.RawTemperature
+					}
+					row.RawTemperature = &raw
+				}
+				if columns.battery {
+					row.Battery = &reading.Battery
+				}
+				if columns.signal {
+					row.SignalStrength = &reading.SignalStrength
+				}
+				if columns.source {
+					row.Source = &reading.Source
+				}
+				if !yield(row) {
+					return
+				}
+			}
+		}
+
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			if err := write(w, rows); err != nil {
+				slog.Debug("export aborted", "error", err, "format", format)
+				return
+			}
+			w.Flush()
+		})
+
+		return nil
+	}
+}
+
+// exportFilename names the export after its range, e.g. temperatures-2024-01-01-2024-12-31.
+func exportFilename(r ReadingRange, location *time.Location) string {
+	to := time.Now()
+	if !r.To.IsZero() {
+		// The range excludes its end
+		to = r.To.Add(-time.Second)
+	}
+	return "temperatures-" + r.From.In(location).Format(DATE_ONLY_FORMAT) + "-" + to.In(location).Format(DATE_ONLY_FORMAT)
+}
+
+func writeCsv(w io.Writer, columns []string, rows iter.Seq[ExportReading]) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	for row := range rows {
+		record := []string{strconv.FormatFloat(row.Temperature, 'f', 1, 64)}
+		if row.RawTemperature != nil {
+			record = append(record, strconv.FormatFloat(*row.RawTemperature, 'f', 1, 64))
+		}
+		record = append(record, row.TimeLocal.Format(LOCAL_TIME_FORMAT), row.TimeUTC.Format(time.RFC3339))
+		if row.Battery != nil {
+			record = append(record, strconv.Itoa(*row.Battery))
+		}
+		if row.SignalStrength != nil {
+			record = append(record, strconv.Itoa(*row.SignalStrength))
+		}
+		if row.Source != nil {
+			record = append(record, *row.Source)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeNdjson(w io.Writer, rows iter.Seq[ExportReading]) error {
+	enc := json.NewEncoder(w)
+	for row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeXlsx(w io.Writer, columns []string, rows iter.Seq[ExportReading]) error {
+	header := make([]any, len(columns))
+	for i, column := range columns {
+		header[i] = column
+	}
+	x, err := NewXlsxWriter(w, "Temperatures", header...)
+	if err != nil {
+		return err
+	}
+	for row := range rows {
+		cells := []any{row.Temperature}
+		if row.RawTemperature != nil {
+			cells = append(cells, *row.RawTemperature)
+		}
+		cells = append(cells, row.TimeLocal, row.TimeUTC)
+		if row.Battery != nil {
+			cells = append(cells, *row.Battery)
+		}
+		if row.SignalStrength != nil {
+			cells = append(cells, *row.SignalStrength)
+		}
+		if row.Source != nil {
+			cells = append(cells, *row.Source)
+		}
+		if err := x.WriteRow(cells...); err != nil {
+			return err
+		}
+	}
+	return x.Close()
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// FEED_DAYS is how many days of summaries the feeds contain
+	FEED_DAYS = 30
+
+	// MORNING_HOUR is the local hour from which a reading counts as the morning reading
+	MORNING_HOUR = 8
+
+	// FEED_TAG_DATE is the date in the tag URIs identifying entries, which must never change
+	FEED_TAG_DATE = "2024"
+
+	FEED_TITLE = "Bude Sea Pool temperature"
+)
+
+// FeedEntry is an entry of the Atom and RSS feeds, either a daily summary or a maintenance announcement.
+type FeedEntry struct {
+	Id        string
+	Title     string
+	Summary   string
+	Link      string
+	Published time.Time
+	Updated   time.Time
+}
+
+// Feed holds the entries of the feeds, latest first.
+type Feed struct {
+	Id      string
+	Link    string
+	Updated time.Time
+	Entries []FeedEntry
+}
+
+// NewFeed creates the feed with the daily summaries of the last [FEED_DAYS] days and the maintenance
+// announcements in that time. Entry IDs are tag URIs on the host of baseURL, which therefore must not change.
+func NewFeed(history *History, announcements []Announcement, now time.Time, location *time.Location, baseURL string) Feed {
+	authority := baseURL
+	if u, err := url.Parse(baseURL); err == nil && u.Host != "" {
+		authority = u.Hostname()
+	}
+	feed := Feed{
+		Id:   fmt.Sprintf("tag:%s,%s:feed", authority, FEED_TAG_DATE),
+		Link: baseURL + "/",
+	}
+
+	now = now.In(location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	since := today.AddDate(0, 0, -FEED_DAYS+1)
+	for day := since; !day.After(today); day = day.AddDate(0, 0, 1) {
+		if entry, ok := dailyFeedEntry(history, day, authority, baseURL); ok {
+			feed.Entries = append(feed.Entries, entry)
+		}
+	}
+
+	for _, a := range announcements {
+		if a.Time.Before(since) {
+			continue
+		}
+		entry := FeedEntry{
+			Id:        fmt.Sprintf("tag:%s,%s:maintenance/%d", authority, FEED_TAG_DATE, a.Time.Unix()),
+			Title:     "Maintenance finished",
+			Summary:   "The pool temperature is shown again.",
+			Link:      baseURL + "/",
+			Published: a.Time,
+			Updated:   a.Time,
+		}
+		if a.Message != "" {
+			entry.Title = "Maintenance: " + strings.ReplaceAll(a.Message, "#", " ")
+			entry.Summary = entry.Title
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	slices.SortFunc(feed.Entries, func(a, b FeedEntry) int {
+		return b.Updated.Compare(a.Updated)
+	})
+	if len(feed.Entries) > 0 {
+		feed.Updated = feed.Entries[0].Updated
+	}
+
+	return feed
+}
+
+// dailyFeedEntry summarises the readings of the local day starting at day, or returns false if there are none.
+// The entry is published at the start of the day, so feed readers don't show it again as it is updated with
+// every reading of the day. It doesn't change once the day is over.
+func dailyFeedEntry(history *History, day time.Time, authority, baseURL string) (FeedEntry, bool) {
+	location := day.Location()
+	stats, ok := history.DailyStats(day, location)
+	if !ok {
+		return FeedEntry{}, false
+	}
+	readings := Accepted(history.Between(day, day.AddDate(0, 0, 1)))
+	date := day.Format(DATE_ONLY_FORMAT)
+
+	entry := FeedEntry{
+		Id:        fmt.Sprintf("tag:%s,%s:daily/%s", authority, FEED_TAG_DATE, date),
+		Link:      fmt.Sprintf("%s/api/v1/temperatures.csv?from=%s&to=%s", baseURL, date, date),
+		Published: day,
+		Updated:   readings[len(readings)-1].Time,
+	}
+
+	var summary []string
+	morningStart := time.Date(day.Year(), day.Month(), day.Day(), MORNING_HOUR, 0, 0, 0, location)
+	morningIndex, _ := slices.BinarySearchFunc(readings, morningStart, func(r Reading, t time.Time) int {
+		return r.Time.Compare(t)
+	})
+	if morningIndex < len(readings) {
+		morning := readings[morningIndex]
+		entry.Title = fmt.Sprintf("%s: %s in the morning", day.Format("Monday 2 January"), formatStatsTemperature(morning.Temperature))
+		summary = append(summary, fmt.Sprintf("Morning reading %s.", formatStatsReading(morning, location)))
+	} else {
+		// No reading in the morning yet, or the sensor was offline
+		entry.Title = fmt.Sprintf("%s: %s", day.Format("Monday 2 January"), formatStatsReading(readings[0], location))
+	}
+	summary = append(summary, fmt.Sprintf("High %s, low %s.", formatStatsReading(stats.High, location), formatStatsReading(stats.Low, location)))
+
+	if previous, ok := history.DailyStats(day.AddDate(0, 0, -1), location); ok {
+		difference := stats.Average - previous.Average
+		switch {
+		case difference >= 0.05:
+			summary = append(summary, fmt.Sprintf("%s warmer than the day before on average.", formatStatsTemperature(difference)))
+		case difference <= -0.05:
+			summary = append(summary, fmt.Sprintf("%s colder than the day before on average.", formatStatsTemperature(-difference)))
+		default:
+			summary = append(summary, "Same as the day before on average.")
+		}
+	}
+	entry.Summary = strings.Join(summary, " ")
+
+	return entry, true
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string   `xml:"title"`
+	Id        string   `xml:"id"`
+	Link      atomLink `xml:"link"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+	Summary   string   `xml:"summary"`
+}
+
+// Atom renders the feed as Atom 1.0.
+func (f Feed) Atom() ([]byte, error) {
+	updated := f.Updated
+	if updated.IsZero() {
+		updated = time.Unix(0, 0)
+	}
+	feed := atomFeed{
+		Title:   FEED_TITLE,
+		Id:      f.Id,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: f.Link},
+			{Href: f.Link + "feed.atom", Rel: "self", Type: "application/atom+xml"},
+		},
+		Author: atomAuthor{Name: FEED_TITLE},
+	}
+	for _, e := range f.Entries {
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     e.Title,
+			Id:        e.Id,
+			Link:      atomLink{Href: e.Link},
+			Published: e.Published.UTC().Format(time.RFC3339),
+			Updated:   e.Updated.UTC().Format(time.RFC3339),
+			Summary:   e.Summary,
+		})
+	}
+
+	return marshalFeed(feed)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Id          string `xml:",chardata"`
+}
+
+// Rss renders the feed as RSS 2.0. RSS has no update time, items are dated by their publication.
+func (f Feed) Rss() ([]byte, error) {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       FEED_TITLE,
+			Link:        f.Link,
+			Description: "Daily temperature summaries and maintenance announcements of Bude Sea Pool",
+		},
+	}
+	if !f.Updated.IsZero() {
+		feed.Channel.LastBuildDate = f.Updated.UTC().Format(time.RFC1123Z)
+	}
+	for _, e := range f.Entries {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			Description: e.Summary,
+			Guid:        rssGuid{Id: e.Id},
+			PubDate:     e.Published.UTC().Format(time.RFC1123Z),
+		})
+	}
+
+	return marshalFeed(feed)
+}
+
+func marshalFeed(feed any) ([]byte, error) {
+	b, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// FeedHandler serves the feed in the format returned by render. Links and entry IDs use publicUrl, never the
+// requested host, as the IDs must be the same for all readers.
+func FeedHandler(history *History, sm *StateManager, monnit *Monnit, location *time.Location, publicUrl, contentType string, render func(Feed) ([]byte, error)) fiber.Handler {
+	baseURL := strings.TrimSuffix(publicUrl, "/")
+	return func(c *fiber.Ctx) error {
+		feed := NewFeed(history, sm.Announcements(), time.Now(), location, baseURL)
+		body, err := render(feed)
+		if err != nil {
+			return err
+		}
+
+		c.Set(fiber.HeaderContentType, contentType)
+		return SendCached(c, body, NewETag(body), feed.Updated, CacheMaxAge(monnit.NextRefresh()))
+	}
+}
@@ -1,37 +1,80 @@
 package main
 
 import (
+	"errors"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/favicon"
 	"github.com/gofiber/template/html/v2"
+	"io/fs"
 	"log/slog"
+	"net/http"
+	"os"
 	"time"
 )
 
-func FiberApp(cfg *Config, sm *StateManager, monnit *Monnit) *fiber.App {
-	generators := make(map[string]*ImageGenerator)
-	generators["temperature"] = NewImageGenerator(cfg.ImageWidth, cfg.ImageHeight, "", GenerateDisplayImage)
-	generators["website"] = NewImageGenerator(300, 125, "", GenerateWebsiteImage)
-	generators["tiny"] = NewImageGenerator(100, 50, "", GenerateTinyImage)
-
-	// In maintenance mode, use maintenance image generators instead
-	if cfg.MaintenanceMessage != "" {
-		generators["temperature"] = NewImageGenerator(cfg.ImageWidth, cfg.ImageHeight, cfg.MaintenanceMessage, GenerateMaintenanceDisplayImage)
-		generators["website"] = NewImageGenerator(300, 125, cfg.MaintenanceMessage, GenerateMaintenanceWebsiteImage)
-		generators["tiny"] = NewImageGenerator(100, 50, cfg.MaintenanceMessage, GenerateMaintenanceTinyImage)
+// AppServices are the parts of the service the app serves requests from.
+type AppServices struct {
+	State        *StateManager
+	Monnit       *Monnit
+	Readings     *Readings
+	History      *History
+	Calibrations *Calibrations
+	Maintenance  *Maintenance
+	Broker       *EventBroker
+	Webhooks     *Webhooks
+	Themes       Themes
+	Conditions   Conditions
+}
+
+// FiberApp sets up the routes of the app, configured by cfg.
+func FiberApp(cfg *Config, s AppServices) *fiber.App {
+	// Images are rendered in the background whenever something they show changes, handlers only read them
+	var stats func() DisplayStats
+	if cfg.ImageStats {
+		stats = func() DisplayStats {
+			return NewDisplayStats(s.History, time.Now(), cfg.Location())
+		}
+	}
+	redrawn := func() {
+		s.State.IncrementImageRedraws(AnalyticsDate(time.Now(), cfg.Location()))
 	}
+	generators := NewImageGenerators(ImageGeneratorsOptions{
+		Width:        cfg.ImageWidth,
+		Height:       cfg.ImageHeight,
+		Themes:       s.Themes,
+		DefaultTheme: cfg.Theme,
+		Conditions:   s.Conditions,
+		Stats:        stats,
+		Redrawn:      redrawn,
+		Location:     cfg.Location(),
+		MaxArea:      cfg.ImageMaxArea,
+		CacheSize:    cfg.ImageCacheSize,
+	}, s.Maintenance.Message(), s.Readings.LastReading())
+	s.Maintenance.OnChange(generators.SetMessage)
+	s.Readings.OnNewReading(generators.RefreshAll)
 
-	engine := html.New("./views", ".html")
+	views, err := fs.Sub(Assets, "views")
+	if err != nil {
+		slog.Error("unable to load views", "error", err)
+		os.Exit(1)
+	}
+	engine := html.NewFileSystem(http.FS(views), ".html")
 
 	app := fiber.New(fiber.Config{
 		AppName:               "Bude Seapool Temperature Display",
 		DisableStartupMessage: true,
 		Views:                 engine,
+		ProxyHeader:           cfg.ProxyHeader,
 	})
 
-	app.Use(favicon.New(favicon.Config{
-		File: "./favicon.png",
-		URL:  "/favicon.ico",
+	// Count all requests by client, route and image type
+	app.Use(Analytics(s.State, cfg.Location(), cfg.SignageUserAgents))
+
+	app.Use("/favicon.ico", favicon.New(favicon.Config{
+		File:       "favicon.png",
+		FileSystem: http.FS(Assets),
+		URL:        "/favicon.ico",
 	}))
 
 	app.Get("/", func(c *fiber.Ctx) error {
@@ -39,41 +82,164 @@ func FiberApp(cfg *Config, sm *StateManager, monnit *Monnit) *fiber.App {
 		return c.Render("index", fiber.Map{
 			"width":  cfg.ImageWidth,
 			"height": cfg.ImageHeight,
+			"theme":  c.Query("theme"),
+			"feeds":  cfg.PublicUrl != "",
+		})
+	})
+
+	// Readings posted by devices other than the Monnit sensor
+	if len(cfg.DeviceTokens) > 0 {
+		app.Post("/api/v1/readings", DeviceAuth(cfg.DeviceTokens), ReadingsHandler(s.History, s.Readings))
+	}
+
+	// Readings pushed by the iMonnit webhook integration
+	if cfg.MonnitWebhookPassword != "" {
+		app.Post("/ingest/monnit", MonnitWebhookAuth(cfg.MonnitWebhookUsername, cfg.MonnitWebhookPassword), MonnitWebhookHandler(s.Monnit))
+	}
+
+	// Daily summaries and maintenance announcements for feed readers, identified by the public URL
+	if cfg.PublicUrl != "" {
+		app.Get("/feed.atom", FeedHandler(s.History, s.State, s.Monnit, cfg.Location(), cfg.PublicUrl, "application/atom+xml; charset=utf-8", Feed.Atom))
+		app.Get("/feed.rss", FeedHandler(s.History, s.State, s.Monnit, cfg.Location(), cfg.PublicUrl, "application/rss+xml; charset=utf-8", Feed.Rss))
+	}
+
+	// Allow browser apps and the widget on other sites to call the API
+	app.Use("/api", cors.New(cors.Config{
+		AllowOrigins:  cfg.CorsAllowOrigins,
+		AllowMethods:  "GET,HEAD",
+		AllowHeaders:  API_KEY_HEADER,
+		ExposeHeaders: "RateLimit-Limit,RateLimit-Remaining,RateLimit-Reset,Retry-After",
+	}))
+
+	// Throttle API requests per client IP, or per API key with higher limits
+	app.Use("/api", RateLimit(
+		NewRateLimiter(cfg.RateLimit, cfg.RateLimitBurst),
+		NewRateLimiter(cfg.ApiKeyRateLimit, cfg.ApiKeyRateLimitBurst),
+		cfg.ApiKeys,
+		s.State,
+	))
+
+	// Widget for partner websites, either as script or as iframe
+	app.Get("/widget.js", func(c *fiber.Ctx) error {
+		b, err := fs.ReadFile(Assets, "static/widget.js")
+		if err != nil {
+			return err
+		}
+		c.Set("Content-Type", "text/javascript; charset=utf-8")
+		c.Set("Cache-Control", "public, max-age=3600")
+		return c.Send(b)
+	})
+
+	app.Get("/widget", func(c *fiber.Ctx) error {
+		c.Set("Cache-Control", "public, max-age=3600")
+		return c.Render("widget", fiber.Map{
+			"size":  c.Query("size", "medium"),
+			"unit":  c.Query("unit", "c"),
+			"theme": c.Query("theme", "light"),
 		})
 	})
 
 	// Public API endpoint to get latest temperature
 	app.Get("/api/v1/temperature", func(c *fiber.Ctx) error {
-		last := monnit.LastReading().ToApiMessage()
-		return c.JSON(&last)
+		reading := s.Readings.LastReading()
+		last := reading.ToApiMessage()
+		return SendCachedJSON(c, &last, time.Time(reading.MessageDate), CacheMaxAge(s.Monnit.NextRefresh()))
 	})
 
-	// Public API endpoint to get a list of the last readings returned by Monnit
+	// Public API endpoint to get a list of the readings of the Monnit sensor in the last seven days, or the
+	// readings in the range of the from and to query parameters
 	app.Get("/api/v1/temperatures", func(c *fiber.Ctx) error {
-		apiResponse := monnit.ToApiResponse()
-		return c.JSON(apiResponse)
+		lastModified := time.Time(s.Readings.LastReading().MessageDate)
+		if !RangeRequested(c) {
+			lastWeek := FilterSources(s.History.Between(time.Now().AddDate(0, 0, -7), time.Time{}), []string{SOURCE_MONNIT})
+			return SendCachedJSON(c, ApiResponseFromReadings(lastWeek), lastModified, CacheMaxAge(s.Monnit.NextRefresh()))
+		}
+
+		r, err := ParseReadingRange(c, cfg.Location())
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(ApiError{Error: err.Error()})
+		}
+		apiResponse := ApiResponseFromReadings(FilterSources(s.History.Between(r.From, r.To), SourcesRequested(c)))
+		return SendCachedJSON(c, apiResponse, lastModified, CacheMaxAge(s.Monnit.NextRefresh()))
 	})
 
-	app.Get(`/:type<regex((temperature|website|tiny))>.png`, func(c *fiber.Ctx) error {
-		imageType := c.Params("type")
-		generator := generators[imageType]
-		last := monnit.LastReading()
-
-		// Refresh image if stale
-		if generator.NeedsUpdate(time.Time(last.MessageDate)) {
-			slog.Debug("Stale image", "update", generator.lastUpdate, "current", time.Time(last.MessageDate), "image_type", imageType)
-			err := generator.Refresh(last)
-			if err != nil {
-				slog.Warn("Unable to refresh image", "error", err, "image_type", imageType)
-				return c.Status(500).SendString(err.Error())
-			}
+	// Swimming condition of the latest reading
+	app.Get("/api/v1/conditions", func(c *fiber.Ctx) error {
+		reading := s.Readings.LastReading()
+		apiConditions := ApiConditions{
+			Temperature:  reading.Temperature,
+			LastModified: reading.MessageDate,
+			Condition:    s.Conditions.For(float64(reading.Temperature)),
+			Bands:        s.Conditions,
 		}
+		return SendCachedJSON(c, &apiConditions, time.Time(reading.MessageDate), CacheMaxAge(s.Monnit.NextRefresh()))
+	})
 
-		sm.IncrementImageRequests()
-		c.Set("Cache-Control", "no-cache")
+	// Exports of the readings in the range of the from and to query parameters
+	app.Get(`/api/v1/temperatures.:format<regex((csv|ndjson|xlsx))>`, ExportHandler(s.History, cfg.Location()))
+
+	// Readings with metadata, v1 stays as it is for existing consumers
+	ApiV2Routes(app.Group("/api/v2"), cfg, s.Monnit, s.Readings, s.History)
+
+	// API documentation
+	openApi := OpenApiDocument()
+	app.Get("/api/v1/openapi.json", func(c *fiber.Ctx) error {
+		return c.JSON(openApi)
+	})
+	app.Get("/api/docs", func(c *fiber.Ctx) error {
+		return c.Render("docs", fiber.Map{})
+	})
+
+	// Live updates whenever a new reading arrives or the maintenance message changes
+	app.Get("/api/v1/stream", StreamHandler(s.Broker, s.Readings, s.Maintenance))
+	app.Use("/api/v1/ws", WebSocketUpgrade)
+	app.Get("/api/v1/ws", WebSocketHandler(s.Broker, s.Readings, s.Maintenance))
+
+	if cfg.AdminToken != "" {
+		AdminRoutes(app.Group("/admin", AdminAuth(cfg.AdminToken)), s.Maintenance, s.State, s.Webhooks, s.Calibrations, s.History, s.Readings)
+	}
+
+	// Images in the size and theme requested, as rendered by the generators
+	sendImage := func(c *fiber.Ctx, imageType, measurement string) error {
+		width, height, err := generators.Size(imageType, c.QueryInt("w"), c.QueryInt("h"), c.QueryInt("scale"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		generator, err := generators.Get(imageType, c.Query("theme"), measurement, width, height)
+		if errors.Is(err, ErrUnknownTheme) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, ErrUnknownMeasurement) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			slog.Warn("Unable to generate image", "error", err, "image_type", imageType)
+			return c.Status(500).SendString(err.Error())
+		}
+
+		img := generator.GetImage()
+		if img == nil {
+			return c.Status(fiber.StatusServiceUnavailable).SendString("image not rendered yet")
+		}
+
+		s.State.IncrementImageRequests()
+		c.Locals("image_type", imageType)
 		c.Set("Content-Type", "image/png")
-		return c.Send(generator.GetImageBytes())
+		return SendCached(c, img.Data, img.ETag, img.LastModified, CacheMaxAge(s.Monnit.NextRefresh()))
+	}
+
+	app.Get(`/:type<regex((temperature|website|tiny))>.png`, func(c *fiber.Ctx) error {
+		return sendImage(c, c.Params("type"), "")
 	})
 
+	// Any quantity measured by the sensor, e.g. /measurements/humidity.png
+	app.Get("/measurements/:name.png", func(c *fiber.Ctx) error {
+		return sendImage(c, IMAGE_MEASUREMENT, c.Params("name"))
+	})
+
+	// Must be last, only requests no route handled reach it
+	app.Use(MarkUnmatched)
+
 	return app
 }
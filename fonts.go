@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/fs"
+	"sync"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+var (
+	fontsMutex sync.Mutex
+	fonts      = make(map[string]*truetype.Font)
+)
+
+// LoadFontFace sets the font face of the context, like [gg.Context.LoadFontFace], but loads the
+// font from the [Assets] and only parses each font once.
+func LoadFontFace(dc *gg.Context, path string, points float64) error {
+	f, err := loadFont(path)
+	if err != nil {
+		return err
+	}
+
+	dc.SetFontFace(fontFace{
+		Face:   truetype.NewFace(f, &truetype.Options{Size: points}),
+		height: fixed.Int26_6(points * 72 / 96 * 64),
+	})
+	return nil
+}
+
+func loadFont(path string) (*truetype.Font, error) {
+	fontsMutex.Lock()
+	defer fontsMutex.Unlock()
+
+	if f, ok := fonts[path]; ok {
+		return f, nil
+	}
+
+	b, err := fs.ReadFile(Assets, path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := truetype.Parse(b)
+	if err != nil {
+		return nil, err
+	}
+	fonts[path] = f
+
+	return f, nil
+}
+
+// fontFace keeps the line height of points*72/96 used by [gg.Context.LoadFontFace], which
+// [gg.Context.SetFontFace] would otherwise take from the font metrics.
+type fontFace struct {
+	font.Face
+	height fixed.Int26_6
+}
+
+func (f fontFace) Metrics() font.Metrics {
+	m := f.Face.Metrics()
+	m.Height = f.height
+	return m
+}
@@ -6,68 +6,128 @@ import (
 	"image/png"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// RenderedImage is one encoded version of an image. It is never modified once published,
+// so its data can be handed to any number of clients without copying or locking.
+type RenderedImage struct {
+	Version      uint64
+	Data         []byte
+	ETag         string
+	LastModified time.Time
+
+	// content are the values shown, taken from the reading last
+	content ImageData
+	last    *SensorDataMessage
+}
+
+// ImageDataFunc returns the values shown on an image for the last reading
+type ImageDataFunc func(last *SensorDataMessage) ImageData
+
+// GenerateImageFunc draws an image of the given size, styled by the theme
+type GenerateImageFunc func(width, height int, theme *ImageTheme, data ImageData) (image.Image, error)
+
 // ImageGenerator generates images for the set width and height, using its generateImage function
 type ImageGenerator struct {
-	sync.RWMutex
+	refreshing    sync.Mutex
 	width         int
 	height        int
-	msg           string
-	buffer        *bytes.Buffer
-	lastUpdate    time.Time
-	generateImage func(width, height int, temperature, lastModified, msg string) (image.Image, error)
+	theme         *ImageTheme
+	data          ImageDataFunc
+	image         atomic.Pointer[RenderedImage]
+	generateImage GenerateImageFunc
+	// redrawn is called after every image rendered, if set
+	redrawn func()
+	// since is when the theme and message were set, the earliest the images can be modified
+	since time.Time
 }
 
-// NewImageGenerator creates a new display with the specified width and height, initializing the display buffer.
-func NewImageGenerator(width, height int, msg string, generateImage func(width, height int, temperature, lastModified, msg string) (image.Image, error)) *ImageGenerator {
+// NewImageGenerator creates a new display with the specified width, height and theme,
+// showing the values returned by data. redrawn, if not nil, is called whenever the image was rendered.
+// since is when the theme and message of the image were set.
+func NewImageGenerator(width, height int, theme *ImageTheme, data ImageDataFunc, generateImage GenerateImageFunc, redrawn func(), since time.Time) *ImageGenerator {
 	return &ImageGenerator{
 		width:         width,
 		height:        height,
-		msg:           msg,
-		buffer:        bytes.NewBuffer([]byte{}),
+		theme:         theme,
+		data:          data,
 		generateImage: generateImage,
+		redrawn:       redrawn,
+		since:         since,
 	}
 }
 
-// GetImageBytes returns the image data as a byte slice by reading from the display buffer.
-// It will be blocked while a call to [ImageGenerator.Refresh] finishes
-func (ig *ImageGenerator) GetImageBytes() []byte {
-	ig.RLock()
-	defer ig.RUnlock()
-
-	return ig.buffer.Bytes()
+// GetImage returns the latest published image, or nil if none has been rendered yet.
+// It never blocks, a concurrent [ImageGenerator.Refresh] publishes a new version instead of touching this one.
+func (ig *ImageGenerator) GetImage() *RenderedImage {
+	return ig.image.Load()
 }
 
-// NeedsUpdate checks if the last update time is before the provided time for comparison.
-func (ig *ImageGenerator) NeedsUpdate(check time.Time) bool {
-	ig.RLock()
-	defer ig.RUnlock()
-
-	return ig.lastUpdate.Before(check)
+// NeedsUpdate checks if the image was rendered from another reading than last.
+func (ig *ImageGenerator) NeedsUpdate(last *SensorDataMessage) bool {
+	img := ig.image.Load()
+	return img == nil || img.last != last
 }
 
 // Refresh generates a new image based on the provided SensorDataMessage
-// It updates the display buffer with the new image and sets the last update time
+// It encodes the image into a new byte slice and publishes it as the next version.
+// Concurrent refreshes are serialised, and skipped if the image already shows the same values. The current
+// reading may be older than the one shown, or the same reading recalibrated, so its date alone doesn't tell.
 func (ig *ImageGenerator) Refresh(last *SensorDataMessage) error {
-	ig.Lock()
-	defer ig.Unlock()
+	ig.refreshing.Lock()
+	defer ig.refreshing.Unlock()
+
+	data := ig.data(last)
+	current := ig.image.Load()
+	if current != nil && current.content == data {
+		if current.last != last {
+			// Same image, only remembered as rendered from last
+			unchanged := *current
+			unchanged.last = last
+			ig.image.Store(&unchanged)
+		}
+		return nil
+	}
 
 	slog.Debug("Refreshing image", "temperature", last.Temperature.String(), "date_time", last.MessageDate.String())
 
-	img, err := ig.generateImage(ig.width, ig.height, last.Temperature.String(), last.MessageDate.String(), ig.msg)
+	img, err := ig.generateImage(ig.width, ig.height, ig.theme.Oriented(ig.width, ig.height), data)
 	if err != nil {
 		return err
 	}
 
-	ig.buffer.Reset()
-	err = png.Encode(ig.buffer, img)
+	buffer := bytes.NewBuffer([]byte{})
+	err = png.Encode(buffer, img)
 	if err != nil {
 		return err
 	}
 
-	ig.lastUpdate = time.Time(last.MessageDate)
+	// Modified with the reading, or when the theme or message changed the image without a new one, but never
+	// in the future. Versions within the same second are told apart by the ETag.
+	var version uint64 = 1
+	if current != nil {
+		version = current.Version + 1
+	}
+	modified := time.Time(last.MessageDate)
+	if ig.since.After(modified) {
+		modified = ig.since
+	}
+	if now := time.Now(); modified.After(now) {
+		modified = now
+	}
+	ig.image.Store(&RenderedImage{
+		Version:      version,
+		Data:         buffer.Bytes(),
+		ETag:         NewETag(buffer.Bytes()),
+		LastModified: modified,
+		content:      data,
+		last:         last,
+	})
+	if ig.redrawn != nil {
+		ig.redrawn()
+	}
 
 	return nil
 }
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// testReading returns the i-th of a series of readings, ten minutes apart.
+func testReading(i int) *SensorDataMessage {
+	return &SensorDataMessage{
+		DataMessageGUID: "guid-" + strconv.Itoa(i),
+		MessageDate:     MessageDate(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC).Add(time.Duration(i) * 10 * time.Minute)),
+		Temperature:     Temperature(15 + float64(i%50)/10),
+	}
+}
+
+// checkImage checks the image decodes as PNG and its ETag matches the data.
+func checkImage(t *testing.T, data []byte, etag string) {
+	t.Helper()
+
+	if got := NewETag(data); got != etag {
+		t.Errorf("ETag %s doesn't match the data, which has %s", etag, got)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("image doesn't decode: %v", err)
+	}
+}
+
+func TestImageGeneratorConcurrentRefresh(t *testing.T) {
+	// Shades the image by the temperature, so readings render different images
+	generate := func(width, height int, theme *ImageTheme, data ImageData) (image.Image, error) {
+		img := image.NewGray(image.Rect(0, 0, width, height))
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{Y: data.Temperature[len(data.Temperature)-3] * 4}), image.Point{}, draw.Src)
+		return img, nil
+	}
+	data := func(last *SensorDataMessage) ImageData {
+		return ImageData{Temperature: last.Temperature.String(), LastModified: last.MessageDate.String()}
+	}
+	var redraws atomic.Int64
+	ig := NewImageGenerator(64, 32, &ImageTheme{}, data, generate, func() { redraws.Add(1) }, time.Time{})
+	if ig.GetImage() != nil {
+		t.Fatal("image before the first refresh")
+	}
+
+	const refreshes = 200
+	var done atomic.Bool
+	var readers sync.WaitGroup
+	for range 8 {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			var version uint64
+			for !done.Load() {
+				img := ig.GetImage()
+				if img == nil {
+					continue
+				}
+				if img.Version < version {
+					t.Errorf("version went back from %d to %d", version, img.Version)
+				}
+				version = img.Version
+				checkImage(t, img.Data, img.ETag)
+			}
+		}()
+	}
+
+	// Concurrent refreshes of the same reading only render it once
+	var refreshing sync.WaitGroup
+	for i := range refreshes {
+		last := testReading(i)
+		for range 2 {
+			refreshing.Add(1)
+			go func() {
+				defer refreshing.Done()
+				if err := ig.Refresh(last); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		refreshing.Wait()
+		if ig.NeedsUpdate(last) {
+			t.Errorf("reading %d not rendered", i)
+		}
+	}
+	done.Store(true)
+	readers.Wait()
+
+	if got := redraws.Load(); got != refreshes {
+		t.Errorf("%d redraws for %d readings", got, refreshes)
+	}
+}
+
+func TestImageGeneratorsServeWhileRefreshing(t *testing.T) {
+	themes, err := LoadThemes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conditions, err := LoadConditions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	igs := NewImageGenerators(ImageGeneratorsOptions{
+		Width:        320,
+		Height:       180,
+		Themes:       themes,
+		DefaultTheme: "light",
+		Conditions:   conditions,
+		Location:     time.UTC,
+		MaxArea:      1 << 20,
+		CacheSize:    4,
+	}, "", testReading(0))
+
+	// Like the image handlers of the app, without the statistics
+	app := fiber.New()
+	app.Get("/:type.png", func(c *fiber.Ctx) error {
+		width, height, err := igs.Size(c.Params("type"), c.QueryInt("w"), 0, 0)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		generator, err := igs.Get(c.Params("type"), c.Query("theme"), "", width, height)
+		if err != nil {
+			return err
+		}
+		img := generator.GetImage()
+		c.Set(fiber.HeaderContentType, "image/png")
+		return SendCached(c, img.Data, img.ETag, img.LastModified, 0)
+	})
+
+	// Each reader makes a fixed number of requests, while the images are refreshed until they are done
+	const requests = 100
+	var readers sync.WaitGroup
+	for _, url := range []string{"/temperature.png", "/website.png", "/tiny.png", "/tiny.png?theme=dark", "/website.png?w=150"} {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for range requests {
+				resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, url, nil), -1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if resp.StatusCode != fiber.StatusOK {
+					t.Errorf("%s: status %d: %s", url, resp.StatusCode, body)
+					return
+				}
+				checkImage(t, body, resp.Header.Get(fiber.HeaderETag))
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		readers.Wait()
+		close(done)
+	}()
+
+	var last *SensorDataMessage
+refreshing:
+	for i := 1; ; i++ {
+		last = testReading(i)
+		igs.RefreshAll(last)
+		if i%2 == 0 {
+			igs.SetMessage("Closed for cleaning")
+			igs.SetMessage("")
+		}
+		select {
+		case <-done:
+			break refreshing
+		default:
+		}
+	}
+
+	// All images show the last reading
+	for _, key := range []ImageKey{{Type: "temperature", Theme: "light"}, {Type: "tiny", Theme: "dark"}} {
+		generator, err := igs.Get(key.Type, key.Theme, "", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if generator.NeedsUpdate(last) {
+			t.Errorf("%s image of the %s theme doesn't show the last reading", key.Type, key.Theme)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Reading is a single reading kept in the history. Unlike [SensorDataMessage] it round-trips through JSON.
+type Reading struct {
+	GUID        string    `json:"guid"`
+	Time        time.Time `json:"time"`
+	Temperature float64   `json:"temperature"`
+	// RawTemperature is the temperature measured before calibration, nil if no calibration applied
+	RawTemperature *float64 `json:"raw_temperature,omitempty"`
+	Battery        int      `json:"battery"`
+	SignalStrength int      `json:"signal_strength"`
+	Voltage        float64  `json:"voltage,omitempty"`
+	// Measurements of sensors measuring more than the temperature, without the temperature
+	Measurements []Measurement `json:"measurements,omitempty"`
+	// Source of the reading, [SOURCE_MONNIT] or the name of a device posting readings
+	Source string `json:"source,omitempty"`
+	// Rejected is the reason the reading failed validation, it is kept but not shown
+	Rejected string `json:"rejected,omitempty"`
+}
+
+// IsRejected returns whether the reading failed validation.
+func (r Reading) IsRejected() bool {
+	return r.Rejected != ""
+}
+
+// NewReading converts a Monnit message to a [Reading]. If the sensor measures more than one quantity, the plot value
+// is the first of them, so the temperature is taken from the data values instead. Readings without a temperature
+// among them are rejected, rather than showing another quantity as temperature.
+func NewReading(m *SensorDataMessage) Reading {
+	r := Reading{
+		GUID:           m.DataMessageGUID,
+		Time:           time.Time(m.MessageDate).UTC(),
+		Temperature:    float64(m.Temperature),
+		Battery:        m.Battery,
+		SignalStrength: m.SignalStrength,
+		Voltage:        m.Voltage,
+		Source:         SOURCE_MONNIT,
+	}
+
+	measurements, err := ParseMonnitMeasurements(m.DataValues, m.DataTypes, m.PlotLabels)
+	if err != nil {
+		slog.Warn("unable to parse data values", "error", err, "guid", m.DataMessageGUID)
+	}
+	if len(measurements) <= 1 {
+		return r
+	}
+	r.Temperature, r.Rejected = math.NaN(), REJECT_NO_TEMPERATURE
+	for _, measurement := range measurements {
+		switch {
+		case measurement.Name == MEASUREMENT_TEMPERATURE:
+			r.Temperature, r.Rejected = measurement.Value, ""
+		case !math.IsNaN(measurement.Value):
+			// NaN can't be written as JSON
+			r.Measurements = append(r.Measurements, measurement)
+		}
+	}
+
+	return r
+}
+
+// ToSensorDataMessage converts the reading to the format the images and live updates use for the current reading.
+func (r Reading) ToSensorDataMessage() *SensorDataMessage {
+	return &SensorDataMessage{
+		DataMessageGUID: r.GUID,
+		MessageDate:     MessageDate(r.Time),
+		SignalStrength:  r.SignalStrength,
+		Voltage:         r.Voltage,
+		Battery:         r.Battery,
+		Temperature:     Temperature(r.Temperature),
+		Measurements:    r.Measurements,
+	}
+}
+
+// ToApiMessage converts the reading to an ApiMessage.
+func (r Reading) ToApiMessage() ApiMessage {
+	return ApiMessage{
+		Temperature:  Temperature(r.Temperature),
+		LastModified: MessageDate(r.Time),
+	}
+}
+
+// History keeps every reading ever loaded, as Monnit only returns the last seven days. Readings are
+// sorted by time and persisted to a file with one JSON reading per line, which only grows by appending.
+// New readings are calibrated and checked by the validator, rejected ones are kept with the reason.
+type History struct {
+	sync.RWMutex
+	filename     string
+	validator    Validator
+	calibrations *Calibrations
+	readings     []Reading
+	guids        map[string]bool
+	// latest accepted reading of every source
+	latest map[string]Reading
+}
+
+// NewHistory loads the history from the file, if it exists.
+func NewHistory(filename string, validator Validator, calibrations *Calibrations) (*History, error) {
+	h := History{
+		filename:     filename,
+		validator:    validator,
+		calibrations: calibrations,
+		guids:        make(map[string]bool),
+		latest:       make(map[string]Reading),
+	}
+
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Info("history not found, starting a new one", "filename", filename)
+		return &h, nil
+	}
+	if err != nil {
+		return &h, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Reading
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			slog.Warn("skipping invalid history line", "error", err, "filename", filename)
+			continue
+		}
+		if h.guids[r.GUID] {
+			continue
+		}
+		// Readings from before there were other sources
+		if r.Source == "" {
+			r.Source = SOURCE_MONNIT
+		}
+		h.guids[r.GUID] = true
+		h.readings = append(h.readings, r)
+	}
+	slices.SortStableFunc(h.readings, compareReadings)
+	for _, r := range h.readings {
+		if !r.IsRejected() {
+			h.latest[r.Source] = r
+		}
+	}
+	slog.Info("loaded history", "filename", filename, "readings", len(h.readings))
+
+	return &h, scanner.Err()
+}
+
+func compareReadings(a, b Reading) int {
+	return a.Time.Compare(b.Time)
+}
+
+// Add merges the Monnit messages into the history, skipping the ones it already has, and saves the new ones.
+func (h *History) Add(messages []SensorDataMessage) error {
+	readings := make([]Reading, 0, len(messages))
+	for i := range messages {
+		readings = append(readings, NewReading(&messages[i]))
+	}
+	_, err := h.AddReadings(readings)
+	return err
+}
+
+// AddReadings calibrates, validates and merges the readings into the history, skipping the ones it already has, saves the
+// new ones and returns how many there were, including rejected ones.
+func (h *History) AddReadings(readings []Reading) (int, error) {
+	h.Lock()
+	defer h.Unlock()
+
+	var added []Reading
+	for _, r := range readings {
+		if r.GUID == "" || h.guids[r.GUID] {
+			continue
+		}
+		h.guids[r.GUID] = true
+		added = append(added, h.calibrations.Correct(r))
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+	slices.SortFunc(added, compareReadings)
+	for i := range added {
+		r := &added[i]
+		if reason := h.validator.Check(*r, h.previous(*r, added[:i])); reason != "" {
+			slog.Warn("rejected reading", "reason", reason, "source", r.Source, "time", r.Time, "temperature", r.Temperature)
+			r.Rejected = reason
+			// NaN can't be written as JSON
+			if math.IsNaN(r.Temperature) {
+				r.Temperature = 0
+			}
+			continue
+		}
+		if latest, ok := h.latest[r.Source]; !ok || !r.Time.Before(latest.Time) {
+			h.latest[r.Source] = *r
+		}
+	}
+
+	// New readings are usually all later than the ones we have, and only need to be appended.
+	// Otherwise the readings are copied, as slices handed out by Between must not change.
+	if len(h.readings) == 0 || !added[0].Time.Before(h.readings[len(h.readings)-1].Time) {
+		h.readings = append(h.readings, added...)
+		return len(added), h.append(added)
+	}
+	merged := make([]Reading, 0, len(h.readings)+len(added))
+	merged = append(append(merged, h.readings...), added...)
+	slices.SortStableFunc(merged, compareReadings)
+	h.readings = merged
+
+	return len(added), h.save()
+}
+
+// previous returns the accepted readings of the source of r before it, oldest first, going back as far as
+// the validator needs. added are the new readings before r, which aren't in the history yet.
+// Must be called with the lock held.
+func (h *History) previous(r Reading, added []Reading) []Reading {
+	var previous []Reading
+	include := func(p Reading) bool {
+		return p.Source == r.Source && !p.IsRejected() && p.Time.Before(r.Time)
+	}
+	for _, p := range added {
+		if include(p) {
+			previous = append(previous, p)
+		}
+	}
+
+	end, _ := slices.BinarySearchFunc(h.readings, r.Time, func(p Reading, t time.Time) int {
+		return p.Time.Compare(t)
+	})
+	found := false
+	for _, p := range slices.Backward(h.readings[:end]) {
+		if found && r.Time.Sub(p.Time) > h.validator.MedianWindow {
+			break
+		}
+		if include(p) {
+			previous = append(previous, p)
+			found = true
+		}
+	}
+
+	slices.SortFunc(previous, compareReadings)
+	return previous
+}
+
+// Recalibrate corrects the readings of the source from the time on again, after its calibrations changed,
+// and saves the history. Whether readings were rejected isn't reconsidered.
+func (h *History) Recalibrate(source string, from time.Time) error {
+	h.Lock()
+	defer h.Unlock()
+
+	// Copied, as slices handed out by Between must not change
+	readings := slices.Clone(h.readings)
+	for i, r := range readings {
+		// Unparseable readings and ones of other quantities have no temperature to correct
+		if r.Source == source && !r.Time.Before(from) && r.Rejected != REJECT_UNPARSEABLE && r.Rejected != REJECT_NO_TEMPERATURE {
+			readings[i] = h.calibrations.Correct(r)
+		}
+	}
+	h.readings = readings
+	for _, r := range h.readings {
+		if r.Source == source && !r.IsRejected() {
+			h.latest[source] = r
+		}
+	}
+
+	return h.save()
+}
+
+// Validator returns the validator checking new readings.
+func (h *History) Validator() Validator {
+	return h.validator
+}
+
+// Latest returns a copy of the latest accepted reading of every source.
+func (h *History) Latest() map[string]Reading {
+	h.RLock()
+	defer h.RUnlock()
+
+	return maps.Clone(h.latest)
+}
+
+// append writes the readings to the end of the history file.
+func (h *History) append(readings []Reading) error {
+	f, err := os.OpenFile(h.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeReadings(f, readings)
+}
+
+// save writes the complete history to a new file, replacing the old one once it is written.
+func (h *History) save() error {
+	f, err := os.Create(h.filename + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = writeReadings(f, h.readings); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(h.filename+".tmp", h.filename)
+}
+
+func writeReadings(f *os.File, readings []Reading) error {
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range readings {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// Between returns the readings from up to but excluding to, oldest first, including rejected ones. Zero times
+// leave the range open.
+// The returned slice is shared with the history and must not be modified, but it can be read without locking
+// while new readings are added.
+func (h *History) Between(from, to time.Time) []Reading {
+	h.RLock()
+	defer h.RUnlock()
+
+	start := 0
+	if !from.IsZero() {
+		start, _ = slices.BinarySearchFunc(h.readings, from, func(r Reading, t time.Time) int {
+			return r.Time.Compare(t)
+		})
+	}
+	end := len(h.readings)
+	if !to.IsZero() {
+		end, _ = slices.BinarySearchFunc(h.readings, to, func(r Reading, t time.Time) int {
+			return r.Time.Compare(t)
+		})
+	}
+	if end < start {
+		end = start
+	}
+
+	return h.readings[start:end:end]
+}
+
+// FilterSources returns the readings of the sources, or all readings if no sources are given.
+func FilterSources(readings []Reading, sources []string) []Reading {
+	if len(sources) == 0 {
+		return readings
+	}
+	return slices.DeleteFunc(slices.Clone(readings), func(r Reading) bool {
+		return !slices.Contains(sources, r.Source)
+	})
+}
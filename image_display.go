@@ -2,69 +2,65 @@ package main
 
 import (
 	"image"
-	"log/slog"
 	"strings"
 
 	"github.com/fogleman/gg"
 )
 
 // GenerateDisplayImage generates the large display image that is displayed at the seapool
-func GenerateDisplayImage(width, height int, temperature, lastModified, msg string) (image.Image, error) {
+func GenerateDisplayImage(width, height int, theme *ImageTheme, data ImageData) (image.Image, error) {
 	dc := gg.NewContext(width, height)
 
-	// White background
-	dc.SetRGB(1, 1, 1)
-	dc.Clear()
-
-	dc.SetRGB(0.3, 0.3, 0.3)
-	if err := dc.LoadFontFace("fonts/Roboto-Bold.ttf", 400); err != nil {
-		slog.Error("unable to load font: ", "error", err)
-		return nil, err
-	}
-	dc.DrawStringAnchored("POOL TEMP", float64(width)/2, 200, 0.5, 0.5)
-
-	// Temperature display
-	dc.SetRGB(0, 0, 0)
-	if err := dc.LoadFontFace("fonts/Roboto-Bold.ttf", 800); err != nil {
-		slog.Error("unable to load font: ", "error", err)
-		return nil, err
-	}
-	dc.DrawStringAnchored(temperature, float64(width)/2, float64(height)/2+100, 0.5, 0.5)
-
-	// Last updated
-	dc.SetRGB(0.5, 0.5, 0.5)
-	if err := dc.LoadFontFace("fonts/Roboto-LightItalic.ttf", 100); err != nil {
-		slog.Error("unable to load font: ", "error", err)
+	if err := theme.Draw(dc, data); err != nil {
 		return nil, err
 	}
-	dc.DrawStringAnchored("Last updated "+lastModified, float64(width)/2, float64(height)-100, 0.5, 0.5)
 
 	return dc.Image(), nil
 }
 
 // GenerateMaintenanceDisplayImage generates a large image with the "Annual maintenance" message
-func GenerateMaintenanceDisplayImage(width, height int, temperature, lastModified, msg string) (image.Image, error) {
+func GenerateMaintenanceDisplayImage(width, height int, theme *ImageTheme, data ImageData) (image.Image, error) {
 	dc := gg.NewContext(width, height)
 
-	// White background
-	dc.SetRGB(1, 1, 1)
-	dc.Clear()
-
-	// Temperature display
-	dc.SetRGB(0.5, 0.5, 0.5)
-	if err := dc.LoadFontFace("fonts/Roboto-Regular.ttf", 400); err != nil {
-		slog.Error("unable to load font: ", "error", err)
+	if err := theme.Draw(dc, data, "message"); err != nil {
 		return nil, err
 	}
-	parts := strings.Split(msg, "#")
+
+	// Maintenance message, split into two lines at "#"
+	e, ok := theme.Element("message")
+	if !ok {
+		return dc.Image(), nil
+	}
+	x, y := e.X*float64(width), e.Y*float64(height)
+	parts := strings.Split(data.Message, "#")
 	switch len(parts) {
 	case 2:
-		dc.DrawStringAnchored(parts[0], float64(width)/2, float64(height)/2, 0.5, -0.25)
-		dc.DrawStringAnchored(parts[1], float64(width)/2, float64(height)/2, 0.5, 1.25)
+		// Both lines share the font size that fits the wider one
+		if err := e.SetFont(dc, ""); err != nil {
+			return nil, err
+		}
+		widest := parts[0]
+		w0, _ := dc.MeasureString(parts[0])
+		w1, _ := dc.MeasureString(parts[1])
+		if w1 > w0 {
+			widest = parts[1]
+		}
+		if err := e.SetFont(dc, widest); err != nil {
+			return nil, err
+		}
+		dc.DrawStringAnchored(parts[0], x, y, 0.5, -0.25)
+		dc.DrawStringAnchored(parts[1], x, y, 0.5, 1.25)
 	case 1:
-		dc.DrawStringAnchored(parts[0], float64(width)/2, float64(height)/2, 0.5, 0.5)
+		if err := e.SetFont(dc, parts[0]); err != nil {
+			return nil, err
+		}
+		dc.DrawStringAnchored(parts[0], x, y, 0.5, 0.5)
 	default:
-		dc.DrawStringWrapped(strings.ReplaceAll(msg, "#", " "), 50, 50, 0, 0, float64(width-100), 1.7, gg.AlignCenter)
+		if err := e.SetFont(dc, ""); err != nil {
+			return nil, err
+		}
+		padding := float64(width) / 50
+		dc.DrawStringWrapped(strings.ReplaceAll(data.Message, "#", " "), padding, padding, 0, 0, float64(width)-2*padding, 1.7, gg.AlignCenter)
 	}
 
 	return dc.Image(), nil
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"image"
+
+	"github.com/fogleman/gg"
+)
+
+// GenerateMeasurementImage generates an image of any quantity measured by the sensor, like the humidity.
+func GenerateMeasurementImage(width, height int, theme *ImageTheme, data ImageData) (image.Image, error) {
+	dc := gg.NewContext(width, height)
+
+	if err := theme.Draw(dc, data); err != nil {
+		return nil, err
+	}
+
+	return dc.Image(), nil
+}
@@ -2,55 +2,39 @@ package main
 
 import (
 	"image"
-	"log/slog"
 	"strings"
 
 	"github.com/fogleman/gg"
 )
 
 // GenerateWebsiteImage generates a smaller image for websites.
-func GenerateWebsiteImage(width, height int, temperature, lastModified, msg string) (image.Image, error) {
+func GenerateWebsiteImage(width, height int, theme *ImageTheme, data ImageData) (image.Image, error) {
 	dc := gg.NewContext(width, height)
 
-	// White background
-	dc.SetRGBA(1, 1, 1, 0)
-	dc.Clear()
-
-	// Temperature display
-	dc.SetRGB(0, 0, 0)
-	if err := dc.LoadFontFace("fonts/Roboto-Regular.ttf", 100); err != nil {
-		slog.Error("unable to load font: ", "error", err)
-		return nil, err
-	}
-	dc.DrawStringAnchored(temperature, float64(width)/2, float64(height)/2, 0.5, 0.25)
-
-	// Last updated
-	dc.SetRGB(0.5, 0.5, 0.5)
-	if err := dc.LoadFontFace("fonts/Roboto-LightItalic.ttf", 15); err != nil {
-		slog.Error("unable to load font: ", "error", err)
+	if err := theme.Draw(dc, data); err != nil {
 		return nil, err
 	}
 
-	dc.DrawStringAnchored("Last updated "+lastModified, float64(width)/2, float64(height)-20, 0.5, 0.5)
-
 	return dc.Image(), nil
 }
 
 // GenerateMaintenanceWebsiteImage generates a smaller image with an "Annual maintenance" message.
-func GenerateMaintenanceWebsiteImage(width, height int, temperature, lastModified, msg string) (image.Image, error) {
+func GenerateMaintenanceWebsiteImage(width, height int, theme *ImageTheme, data ImageData) (image.Image, error) {
 	dc := gg.NewContext(width, height)
 
-	// White background
-	dc.SetRGBA(1, 1, 1, 0)
-	dc.Clear()
+	if err := theme.Draw(dc, data, "message"); err != nil {
+		return nil, err
+	}
 
-	// Temperature display
-	dc.SetRGB(0.5, 0.5, 0.5)
-	if err := dc.LoadFontFace("fonts/Roboto-Regular.ttf", 30); err != nil {
-		slog.Error("unable to load font: ", "error", err)
+	// Maintenance message, wrapped to fit
+	e, ok := theme.Element("message")
+	if !ok {
+		return dc.Image(), nil
+	}
+	if err := e.SetFont(dc, ""); err != nil {
 		return nil, err
 	}
-	msg = strings.Replace(msg, "#", " ", -1)
+	msg := strings.Replace(data.Message, "#", " ", -1)
 	padding := 13
 	dc.DrawStringWrapped(msg, float64(padding), float64(padding), 0, 0, float64(width-2*padding), 1.7, gg.AlignCenter)
 
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MIN_IMAGE_SIZE  = 16
+	MAX_IMAGE_SIZE  = 8192
+	MAX_IMAGE_SCALE = 4
+)
+
+var (
+	ErrUnknownImageType   = errors.New("unknown image type")
+	ErrUnknownTheme       = errors.New("unknown theme")
+	ErrUnknownMeasurement = errors.New("unknown measurement")
+	ErrInvalidSize        = errors.New("invalid image size")
+)
+
+// ImageKey identifies a generator by image type, theme and size. Width and height are
+// zero for the default size of the image type. Measurement names the quantity shown on
+// measurement images, and is empty for all other image types.
+type ImageKey struct {
+	Type        string
+	Theme       string
+	Measurement string
+	Width       int
+	Height      int
+}
+
+// clone returns a copy of the key that doesn't share memory with the request it was parsed from,
+// as Fiber reuses the buffers of the strings it returns once the handler is done.
+func (k ImageKey) clone() ImageKey {
+	k.Type, k.Theme, k.Measurement = strings.Clone(k.Type), strings.Clone(k.Theme), strings.Clone(k.Measurement)
+	return k
+}
+
+// imageType defines the size and generate functions of an image type
+type imageType struct {
+	width               int
+	height              int
+	generate            GenerateImageFunc
+	generateMaintenance GenerateImageFunc
+}
+
+// ImageGenerators holds an [ImageGenerator] per image type and theme. While a maintenance message is set,
+// the maintenance generators are used instead. Generators for the default theme always exist, the others
+// are created when first requested. Updates are serialised by the updating mutex, while the embedded
+// mutex only guards swapping the generators.
+//
+// Generators for custom sizes are kept in a least recently used cache instead, and only rendered when
+// requested, so rarely used sizes don't cost anything on new readings.
+type ImageGenerators struct {
+	sync.RWMutex
+	updating     sync.Mutex
+	types        map[string]imageType
+	themes       Themes
+	conditions   Conditions
+	stats        func() DisplayStats
+	redrawn      func()
+	location     *time.Location
+	defaultTheme string
+	msg          string
+	// changed is when the message was last set, first on startup along with the themes
+	changed    time.Time
+	last       *SensorDataMessage
+	generators map[ImageKey]*ImageGenerator
+	maxArea    int
+	cacheSize  int
+	cache      map[ImageKey]*list.Element
+	recent     *list.List
+}
+
+// cacheEntry is an element of the recently used list
+type cacheEntry struct {
+	key       ImageKey
+	generator *ImageGenerator
+}
+
+// ImageGeneratorsOptions configures the image generators.
+type ImageGeneratorsOptions struct {
+	// Size of the display image, the other image types have a fixed default size
+	Width  int
+	Height int
+
+	Themes       Themes
+	DefaultTheme string
+	Conditions   Conditions
+
+	// Stats, if set, returns the statistics shown on images with a stats styling
+	Stats func() DisplayStats
+	// Redrawn, if set, is called for every image rendered
+	Redrawn func()
+	// Location times are shown in
+	Location *time.Location
+
+	// Custom sizes are limited to MaxArea pixels, and up to CacheSize of their generators are kept
+	MaxArea   int
+	CacheSize int
+}
+
+// NewImageGenerators creates the generators for the default theme and renders their images for the last reading,
+// with the maintenance message msg if set.
+func NewImageGenerators(opts ImageGeneratorsOptions, msg string, last *SensorDataMessage) *ImageGenerators {
+	igs := ImageGenerators{
+		types: map[string]imageType{
+			"temperature": {opts.Width, opts.Height, GenerateDisplayImage, GenerateMaintenanceDisplayImage},
+			"website":     {300, 125, GenerateWebsiteImage, GenerateMaintenanceWebsiteImage},
+			"tiny":        {100, 50, GenerateTinyImage, GenerateMaintenanceTinyImage},
+			// Same size as the website image, so it can share the maintenance image
+			IMAGE_MEASUREMENT: {300, 125, GenerateMeasurementImage, GenerateMaintenanceWebsiteImage},
+		},
+		themes:       opts.Themes,
+		conditions:   opts.Conditions,
+		stats:        opts.Stats,
+		redrawn:      opts.Redrawn,
+		location:     opts.Location,
+		defaultTheme: opts.DefaultTheme,
+		last:         last,
+		generators:   make(map[ImageKey]*ImageGenerator),
+		maxArea:      opts.MaxArea,
+		cacheSize:    opts.CacheSize,
+		cache:        make(map[ImageKey]*list.Element),
+		recent:       list.New(),
+	}
+	// Placeholders for the default theme, SetMessage creates the actual generators. Measurement images
+	// are created when first requested, like other themes.
+	for _, t := range IMAGE_TYPES {
+		igs.generators[ImageKey{Type: t, Theme: opts.DefaultTheme}] = nil
+	}
+	igs.SetMessage(msg)
+
+	return &igs
+}
+
+// newGenerator creates the generator for the key, which has to be valid, showing msg since it was set.
+func (igs *ImageGenerators) newGenerator(key ImageKey, msg string, since time.Time) *ImageGenerator {
+	t := igs.types[key.Type]
+	theme := igs.themes[key.Theme].ImageTheme(key.Type, msg)
+	width, height := t.width, t.height
+	if key.Width != 0 {
+		width, height = key.Width, key.Height
+	}
+
+	// In maintenance mode, use maintenance image generators instead
+	if msg != "" {
+		return NewImageGenerator(width, height, theme, igs.imageData(msg, key.Measurement, false), t.generateMaintenance, igs.redrawn, since)
+	}
+	if igs.stats != nil && theme.Stats != nil {
+		return NewImageGenerator(width, height, theme.Stats, igs.imageData(msg, key.Measurement, true), t.generate, igs.redrawn, since)
+	}
+	return NewImageGenerator(width, height, theme, igs.imageData(msg, key.Measurement, false), t.generate, igs.redrawn, since)
+}
+
+// imageData returns the function providing the values shown on the images, including the measurement and the
+// statistics if requested.
+func (igs *ImageGenerators) imageData(msg, measurement string, stats bool) ImageDataFunc {
+	return func(last *SensorDataMessage) ImageData {
+		data := ImageData{
+			Temperature:  last.Temperature.String(),
+			LastModified: last.MessageDate.StringIn(igs.location),
+			Message:      msg,
+		}
+		if band := igs.conditions.For(float64(last.Temperature)); band != nil {
+			data.Condition, data.ConditionColor = band.Name, band.Color
+		}
+		if measurement != "" {
+			// The latest reading may be of a source that doesn't measure it
+			data.Label, data.Measurement = measurement, "–"
+			if m, ok := last.Measurement(measurement); ok {
+				data.Label, data.Measurement = m.Label, m.String()
+			}
+		}
+		if stats {
+			data.Stats = igs.stats()
+		}
+		return data
+	}
+}
+
+// Size returns the size of the image type for the requested width, height and scale, any of which may be zero.
+// A missing width or height keeps the aspect ratio of the default size. It returns zero width and height for the
+// default size, and [ErrInvalidSize] for sizes out of bounds.
+func (igs *ImageGenerators) Size(imageType string, width, height, scale int) (int, int, error) {
+	t, ok := igs.types[imageType]
+	if !ok {
+		return 0, 0, ErrUnknownImageType
+	}
+	if width == 0 && height == 0 && scale <= 1 {
+		return 0, 0, nil
+	}
+
+	switch {
+	case width == 0 && height == 0:
+		width, height = t.width, t.height
+	case width == 0:
+		width = height * t.width / t.height
+	case height == 0:
+		height = width * t.height / t.width
+	}
+
+	if scale == 0 {
+		scale = 1
+	}
+	if scale < 1 || scale > MAX_IMAGE_SCALE {
+		return 0, 0, fmt.Errorf("%w: scale must be between 1 and %d", ErrInvalidSize, MAX_IMAGE_SCALE)
+	}
+	width, height = width*scale, height*scale
+
+	if width < MIN_IMAGE_SIZE || height < MIN_IMAGE_SIZE || width > MAX_IMAGE_SIZE || height > MAX_IMAGE_SIZE {
+		return 0, 0, fmt.Errorf("%w: width and height must be between %d and %d", ErrInvalidSize, MIN_IMAGE_SIZE, MAX_IMAGE_SIZE)
+	}
+	if width*height > igs.maxArea {
+		return 0, 0, fmt.Errorf("%w: must not exceed %d pixels", ErrInvalidSize, igs.maxArea)
+	}
+	if width == t.width && height == t.height {
+		return 0, 0, nil
+	}
+
+	return width, height, nil
+}
+
+// SetMessage replaces all generators, using the maintenance generators if msg is set.
+// The new images are rendered before they replace the current ones.
+func (igs *ImageGenerators) SetMessage(msg string) {
+	igs.updating.Lock()
+	defer igs.updating.Unlock()
+
+	changed := time.Now()
+	generators := make(map[ImageKey]*ImageGenerator)
+	for key := range igs.generators {
+		generators[key] = igs.newGenerator(key, msg, changed)
+	}
+	refresh(generators, igs.last)
+
+	igs.Lock()
+	defer igs.Unlock()
+	igs.msg = msg
+	igs.changed = changed
+	igs.generators = generators
+
+	// Custom sizes are created again when next requested
+	clear(igs.cache)
+	igs.recent.Init()
+}
+
+// RefreshAll renders the images of all generators for a new reading.
+func (igs *ImageGenerators) RefreshAll(last *SensorDataMessage) {
+	igs.updating.Lock()
+	defer igs.updating.Unlock()
+
+	igs.Lock()
+	igs.last = last
+	igs.Unlock()
+
+	refresh(igs.generators, last)
+}
+
+// refresh renders all generators concurrently, logging any failures.
+func refresh(generators map[ImageKey]*ImageGenerator, last *SensorDataMessage) {
+	var wg sync.WaitGroup
+	for key, generator := range generators {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := generator.Refresh(last); err != nil {
+				slog.Warn("Unable to refresh image", "error", err, "image_type", key.Type, "theme", key.Theme)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Get returns the generator for the image type, theme and size, using the default theme if none is given,
+// and the default size for a zero width and height as returned by [ImageGenerators.Size]. Measurement images
+// need the name of a quantity the latest reading has, other image types an empty measurement.
+// Generators for other themes are created and rendered on first use, and kept up to date from then on.
+func (igs *ImageGenerators) Get(imageType, theme, measurement string, width, height int) (*ImageGenerator, error) {
+	if theme == "" {
+		theme = igs.defaultTheme
+	}
+	if _, ok := igs.types[imageType]; !ok || (imageType == IMAGE_MEASUREMENT) != (measurement != "") {
+		return nil, ErrUnknownImageType
+	}
+	if t, ok := igs.themes[theme]; !ok {
+		return nil, ErrUnknownTheme
+	} else if !t.Styles(imageType) {
+		return nil, fmt.Errorf("%w: %s has no %s images", ErrUnknownTheme, theme, imageType)
+	}
+	if measurement != "" {
+		// Only names measured are accepted, so requests can't create any number of generators
+		igs.RLock()
+		_, ok := igs.last.Measurement(measurement)
+		igs.RUnlock()
+		if !ok {
+			return nil, ErrUnknownMeasurement
+		}
+	}
+	key := ImageKey{Type: imageType, Theme: theme, Measurement: measurement, Width: width, Height: height}
+	if width != 0 {
+		return igs.getCached(key)
+	}
+
+	igs.RLock()
+	generator, ok := igs.generators[key]
+	igs.RUnlock()
+	if ok {
+		return generator, nil
+	}
+
+	igs.updating.Lock()
+	defer igs.updating.Unlock()
+
+	// Another request may have created it while waiting
+	if generator, ok = igs.generators[key]; ok {
+		return generator, nil
+	}
+
+	key = key.clone()
+	generator = igs.newGenerator(key, igs.msg, igs.changed)
+	if err := generator.Refresh(igs.last); err != nil {
+		return nil, err
+	}
+
+	igs.Lock()
+	defer igs.Unlock()
+	igs.generators[key] = generator
+
+	return generator, nil
+}
+
+// getCached returns the generator for a custom size from the cache, creating it if needed and
+// evicting the least recently used generator if the cache is full. The image is rendered if stale.
+func (igs *ImageGenerators) getCached(key ImageKey) (*ImageGenerator, error) {
+	igs.Lock()
+	var generator *ImageGenerator
+	if e, ok := igs.cache[key]; ok {
+		igs.recent.MoveToFront(e)
+		generator = e.Value.(*cacheEntry).generator
+	} else {
+		key = key.clone()
+		generator = igs.newGenerator(key, igs.msg, igs.changed)
+		igs.cache[key] = igs.recent.PushFront(&cacheEntry{key: key, generator: generator})
+		for igs.recent.Len() > igs.cacheSize {
+			oldest := igs.recent.Back()
+			igs.recent.Remove(oldest)
+			delete(igs.cache, oldest.Value.(*cacheEntry).key)
+			slog.Debug("evicted image generator", "image_type", oldest.Value.(*cacheEntry).key.Type)
+		}
+	}
+	last := igs.last
+	igs.Unlock()
+
+	// Concurrent requests for the same size wait for a single render
+	if generator.NeedsUpdate(last) {
+		if err := generator.Refresh(last); err != nil {
+			return nil, err
+		}
+	}
+
+	return generator, nil
+}
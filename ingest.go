@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/basicauth"
+	"github.com/gofiber/fiber/v2/middleware/keyauth"
+)
+
+// MONNIT_WEBHOOK_DATE_FORMAT is the format of the UTC dates in Monnit webhooks
+const MONNIT_WEBHOOK_DATE_FORMAT = "2006-01-02 15:04:05"
+
+const (
+	// INGEST_MAX_READINGS is how many readings can be posted at once
+	INGEST_MAX_READINGS = 1000
+
+	// INGEST_CLOCK_SKEW is how far in the future timestamps of posted readings may be
+	INGEST_CLOCK_SKEW = 5 * time.Minute
+)
+
+var sourcePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// MonnitWebhook is the payload posted by the iMonnit webhook integration. Unlike the API, it has all
+// values as strings. See https://monnit.com/support/documentation/imonnit-webhook/
+type MonnitWebhook struct {
+	GatewayMessage struct {
+		GatewayID string `json:"gatewayID"`
+	} `json:"gatewayMessage"`
+	SensorMessages []MonnitWebhookMessage `json:"sensorMessages"`
+}
+
+type MonnitWebhookMessage struct {
+	SensorID        string `json:"sensorID"`
+	DataMessageGUID string `json:"dataMessageGUID"`
+	State           string `json:"state"`
+	MessageDate     string `json:"messageDate"`
+	RawData         string `json:"rawData"`
+	DataType        string `json:"dataType"`
+	DataValue       string `json:"dataValue"`
+	PlotValues      string `json:"plotValues"`
+	PlotLabels      string `json:"plotLabels"`
+	BatteryLevel    string `json:"batteryLevel"`
+	SignalStrength  string `json:"signalStrength"`
+	Voltage         string `json:"voltage"`
+}
+
+// ToSensorDataMessage converts the message to the format returned by the Monnit API. The temperature is
+// taken from the data values, which are always Celsius, rather than the plot values in the account's unit.
+func (w MonnitWebhookMessage) ToSensorDataMessage(gatewayID string) (SensorDataMessage, error) {
+	date, err := time.Parse(MONNIT_WEBHOOK_DATE_FORMAT, w.MessageDate)
+	if err != nil {
+		return SensorDataMessage{}, err
+	}
+	// Like in the API, values that can't be parsed are kept and rejected by the validator
+	temperature := math.NaN()
+	measurements, _ := ParseMonnitMeasurements(w.DataValue, w.DataType, w.PlotLabels)
+	for _, m := range measurements {
+		if m.Name == MEASUREMENT_TEMPERATURE || len(measurements) == 1 {
+			temperature = m.Value
+			break
+		}
+	}
+
+	// Missing health values are left at zero, like in the API
+	sensorID, _ := strconv.Atoi(w.SensorID)
+	state, _ := strconv.Atoi(w.State)
+	battery, _ := strconv.Atoi(w.BatteryLevel)
+	signal, _ := strconv.Atoi(w.SignalStrength)
+	voltage, _ := strconv.ParseFloat(w.Voltage, 64)
+	gateway, _ := strconv.Atoi(gatewayID)
+
+	return SensorDataMessage{
+		DataMessageGUID: w.DataMessageGUID,
+		SensorID:        sensorID,
+		MessageDate:     MessageDate(date),
+		State:           state,
+		SignalStrength:  signal,
+		Voltage:         voltage,
+		Battery:         battery,
+		Data:            w.RawData,
+		DisplayData:     w.DataValue,
+		Temperature:     Temperature(temperature),
+		GatewayID:       gateway,
+		DataValues:      w.DataValue,
+		DataTypes:       w.DataType,
+		PlotValues:      w.PlotValues,
+		PlotLabels:      w.PlotLabels,
+	}, nil
+}
+
+// IngestResponse tells Monnit how many of the posted messages were new.
+type IngestResponse struct {
+	Received int `json:"received"`
+	Added    int `json:"added"`
+}
+
+// MonnitWebhookAuth checks the basic authentication configured for the iMonnit webhook.
+func MonnitWebhookAuth(username, password string) fiber.Handler {
+	return basicauth.New(basicauth.Config{
+		Authorizer: func(u, p string) bool {
+			userOk := subtle.ConstantTimeCompare([]byte(u), []byte(username)) == 1
+			passwordOk := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+			return userOk && passwordOk
+		},
+	})
+}
+
+// MonnitWebhookHandler merges the readings posted by the iMonnit webhook, so they show without waiting for
+// the next time the API is polled.
+func MonnitWebhookHandler(monnit *Monnit) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var webhook MonnitWebhook
+		if err := c.BodyParser(&webhook); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		messages := make([]SensorDataMessage, 0, len(webhook.SensorMessages))
+		for _, w := range webhook.SensorMessages {
+			msg, err := w.ToSensorDataMessage(webhook.GatewayMessage.GatewayID)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+			messages = append(messages, msg)
+		}
+
+		added, err := monnit.Ingest(messages)
+		if err != nil {
+			return err
+		}
+		return c.JSON(IngestResponse{Received: len(messages), Added: added})
+	}
+}
+
+// IngestReading is a reading posted by a device. Without timestamp it is taken now, and without unit it
+// is in °C. The source defaults to the name of the device, and can only be set to a sub-source of it, e.g.
+// esp32-deep for a second probe of the device esp32.
+type IngestReading struct {
+	// Id makes posting the same reading again harmless, e.g. when retrying after a timeout
+	Id        string     `json:"id,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Value     *float64   `json:"value"`
+	Unit      string     `json:"unit,omitempty"`
+	Source    string     `json:"source,omitempty"`
+}
+
+// ToReading validates the reading and converts it to a [Reading] in °C. Temperatures outside the range of the
+// validator are refused, rather than stored as rejected readings.
+func (i IngestReading) ToReading(device string, now time.Time, validator Validator) (Reading, error) {
+	if i.Value == nil {
+		return Reading{}, errors.New("value is missing")
+	}
+
+	temperature := *i.Value
+	switch strings.ToLower(strings.TrimPrefix(i.Unit, "°")) {
+	case "", "c", "celsius":
+	case "f", "fahrenheit":
+		temperature = (temperature - 32) * 5 / 9
+	default:
+		return Reading{}, fmt.Errorf("unknown unit %q, must be C or F", i.Unit)
+	}
+	if !validator.InRange(temperature) {
+		return Reading{}, fmt.Errorf("temperature %.1f°C is out of range %g°C to %g°C", temperature, validator.MinTemperature, validator.MaxTemperature)
+	}
+
+	t := now
+	if i.Timestamp != nil {
+		t = *i.Timestamp
+	}
+	if t.After(now.Add(INGEST_CLOCK_SKEW)) {
+		return Reading{}, fmt.Errorf("timestamp %s is in the future", t.Format(time.RFC3339))
+	}
+
+	source := device
+	if i.Source != "" {
+		source = i.Source
+	}
+	if !sourcePattern.MatchString(source) || source == SOURCE_MONNIT {
+		return Reading{}, fmt.Errorf("invalid source %q", source)
+	}
+	// Devices can't post readings as another device
+	if source != device && !strings.HasPrefix(source, device+"-") {
+		return Reading{}, fmt.Errorf("source %q is neither %s nor one of its sub-sources %s-…", source, device, device)
+	}
+
+	// Prefixed, so IDs of different sources can't clash with each other or Monnit's GUIDs
+	id := i.Id
+	if id == "" {
+		id = rand.Text()
+	}
+
+	return Reading{
+		GUID:        source + ":" + id,
+		Time:        t.UTC().Truncate(time.Second),
+		Temperature: math.Round(temperature*100) / 100,
+		Source:      source,
+	}, nil
+}
+
+// ValidateDeviceNames checks the names of the devices can be used as sources. Names can't start with the name of
+// another device and a dash either, as that would be a sub-source of the other device.
+func ValidateDeviceNames(tokens map[string]string) error {
+	for name := range tokens {
+		if !sourcePattern.MatchString(name) || name == SOURCE_MONNIT {
+			return fmt.Errorf("invalid device name %q, must be lower case letters, digits, - and _ and not %s", name, SOURCE_MONNIT)
+		}
+		for other := range tokens {
+			if strings.HasPrefix(name, other+"-") {
+				return fmt.Errorf("device name %q is a sub-source of device %q", name, other)
+			}
+		}
+	}
+	return nil
+}
+
+// DeviceAuth only lets requests with a device token as bearer token through, and stores the device name in the
+// "device" local.
+func DeviceAuth(tokens map[string]string) fiber.Handler {
+	return keyauth.New(keyauth.Config{
+		Validator: func(c *fiber.Ctx, key string) (bool, error) {
+			for name, token := range tokens {
+				if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+					c.Locals("device", name)
+					return true, nil
+				}
+			}
+			return false, keyauth.ErrMissingOrMalformedAPIKey
+		},
+	})
+}
+
+// ReadingsHandler stores a single reading or an array of readings posted by a device. Nothing is stored if any
+// of the readings is invalid.
+func ReadingsHandler(history *History, readings *Readings) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var posted []IngestReading
+		body := bytes.TrimSpace(c.Body())
+		if bytes.HasPrefix(body, []byte("[")) {
+			if err := json.Unmarshal(body, &posted); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(ApiError{Error: err.Error()})
+			}
+		} else {
+			var single IngestReading
+			if err := json.Unmarshal(body, &single); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(ApiError{Error: err.Error()})
+			}
+			posted = append(posted, single)
+		}
+		if len(posted) == 0 || len(posted) > INGEST_MAX_READINGS {
+			return c.Status(fiber.StatusBadRequest).JSON(ApiError{Error: fmt.Sprintf("between 1 and %d readings can be posted", INGEST_MAX_READINGS)})
+		}
+
+		device, _ := c.Locals("device").(string)
+		now := time.Now()
+		validator := history.Validator()
+		converted := make([]Reading, 0, len(posted))
+		for i, p := range posted {
+			r, err := p.ToReading(device, now, validator)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(ApiError{Error: fmt.Sprintf("reading %d: %s", i, err)})
+			}
+			converted = append(converted, r)
+		}
+
+		added, err := history.AddReadings(converted)
+		if err != nil {
+			return err
+		}
+		if added > 0 {
+			readings.Update()
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(IngestResponse{Received: len(converted), Added: added})
+	}
+}
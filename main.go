@@ -4,6 +4,8 @@ import (
 	"github.com/gofiber/fiber/v2/log"
 	"log/slog"
 	"os"
+	"time"
+	_ "time/tzdata"
 )
 
 //TIP To run your code, right-click the code and select <b>Run</b>. Alternatively, click
@@ -17,23 +19,130 @@ func main() {
 		slog.Debug("config", "config", cfg)
 	}
 
-	if cfg.SensorId == "" || cfg.ApiKeyId == "" || cfg.ApiSecretKey == "" || cfg.ApiUrl == "" {
+	// Commands like "state dump" run instead of the service
+	if len(os.Args) > 1 {
+		os.Exit(RunCommand(cfg, os.Args[1:]))
+	}
+
+	// Readings are polled from the Monnit API, pushed by the Monnit webhook, or both
+	polling := cfg.RefreshInterval > 0
+	if cfg.SensorId == "" || (polling && (cfg.ApiKeyId == "" || cfg.ApiSecretKey == "" || cfg.ApiUrl == "")) {
 		slog.Error("missing configuration", "config", cfg)
 		os.Exit(1)
 	}
+	if !polling && cfg.MonnitWebhookPassword == "" {
+		slog.Error("either polling or the Monnit webhook must be enabled", "config", cfg)
+		os.Exit(1)
+	}
+
+	// Use custom assets, falling back to the embedded ones
+	SetAssetsDir(cfg.AssetsDir)
+
+	themes, err := LoadThemes()
+	if err != nil {
+		slog.Error("unable to load themes", "error", err)
+		os.Exit(1)
+	}
+	if _, ok := themes[cfg.Theme]; !ok {
+		slog.Error("unknown theme", "theme", cfg.Theme)
+		os.Exit(1)
+	}
+
+	conditions, err := LoadConditions(cfg.ConditionsFile)
+	if err != nil {
+		slog.Error("unable to load condition bands", "error", err)
+		os.Exit(1)
+	}
 
 	// Initiate sensor reader
-	monnit := NewMonnit(cfg.SensorId, cfg.ApiKeyId, cfg.ApiSecretKey, cfg.ApiUrl, cfg.RefreshInterval)
+	pushed := cfg.MonnitWebhookPassword != "" || len(cfg.DeviceTokens) > 0
+	monnit := NewMonnit(cfg.SensorId, cfg.ApiKeyId, cfg.ApiSecretKey, cfg.ApiUrl, cfg.RefreshInterval, pushed)
+
+	calibrations, err := LoadCalibrations(cfg.CalibrationsFile)
+	if err != nil {
+		slog.Error("unable to load calibrations", "error", err)
+		os.Exit(1)
+	}
+
+	// Keep all readings, starting with the ones already loaded, correcting them and rejecting glitches
+	history, err := NewHistory(cfg.HistoryFile, NewValidator(cfg), calibrations)
+	if err != nil {
+		// Not starting with a partial history, which the next save would overwrite
+		slog.Error("unable to load history", "error", err, "filename", cfg.HistoryFile)
+		os.Exit(1)
+	}
+	if err = history.Add(monnit.Messages()); err != nil {
+		slog.Error("unable to save history", "error", err)
+	}
+	// The current reading is selected from all sources once the Monnit readings are in the history
+	readings := NewReadings(history, cfg.SourceMode, cfg.SourcePriority, cfg.StaleAfter)
+	monnit.OnNewReading(func(*SensorDataMessage) {
+		if err := history.Add(monnit.Messages()); err != nil {
+			slog.Error("unable to save history", "error", err)
+		}
+		readings.Update()
+	})
 
 	// Initiate state
 	sm, err := NewStateManager(cfg.StateFile, cfg.StateAutosaveInterval)
 	if err != nil {
-		slog.Error("unable to load or create state", "error", err)
+		// Not starting with an empty state, which would overwrite it
+		slog.Error("unable to load state", "error", err, "filename", cfg.StateFile)
+		os.Exit(1)
 	}
 	slog.Debug("loaded application state", "state", sm.state, "filename", sm.filename)
 
+	broker := NewEventBroker()
+	maintenance := NewMaintenance(cfg.MaintenanceMessage)
+
+	subscriptions, err := LoadWebhookSubscriptions(cfg.WebhooksFile)
+	if err != nil {
+		slog.Error("unable to load webhook subscriptions", "error", err)
+		os.Exit(1)
+	}
+	webhooks, err := NewWebhooks(subscriptions, cfg.WebhookLogFile)
+	if err != nil {
+		// Not starting without the delivery log, which the next delivery would overwrite
+		slog.Error("unable to load webhook deliveries", "error", err, "filename", cfg.WebhookLogFile)
+		os.Exit(1)
+	}
+
 	// Set up Fiber app
-	app := FiberApp(cfg, sm, monnit)
+	app := FiberApp(cfg, AppServices{
+		State:        sm,
+		Monnit:       monnit,
+		Readings:     readings,
+		History:      history,
+		Calibrations: calibrations,
+		Maintenance:  maintenance,
+		Broker:       broker,
+		Webhooks:     webhooks,
+		Themes:       themes,
+		Conditions:   conditions,
+	})
+
+	// Publish live updates and webhooks whenever a new reading arrives or the maintenance message changes.
+	// Registered after the app, so the images have been switched over by the time clients hear about it.
+	readings.OnNewReading(func(last *SensorDataMessage) {
+		broker.Publish(NewReadingEvent(last))
+		webhooks.Publish(NewReadingEvent(last))
+	})
+	maintenance.OnChange(func(msg string) {
+		broker.Publish(NewMaintenanceEvent(msg))
+		webhooks.Publish(NewMaintenanceEvent(msg))
+	})
+
+	// Publish readings to MQTT. They are queued, so the other handlers aren't held up while the broker is unreachable.
+	if cfg.MqttBroker != "" {
+		mqttPublisher := NewMqttPublisher(cfg, readings)
+		readings.OnNewReading(mqttPublisher.Publish)
+	}
+
+	// Announce maintenance in the feeds, including a message set at startup
+	sm.AnnounceMaintenance(maintenance.Message(), time.Now())
+	maintenance.OnChange(func(msg string) {
+		sm.AnnounceMaintenance(msg, time.Now())
+	})
 
 	// Start app server
 	log.Fatal(app.Listen(cfg.Address))
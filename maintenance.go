@@ -0,0 +1,51 @@
+package main
+
+import (
+	"sync"
+)
+
+// Maintenance holds the current maintenance message. While it is set, the images show the message
+// instead of the temperature.
+type Maintenance struct {
+	sync.RWMutex
+	message  string
+	onChange []func(msg string)
+}
+
+func NewMaintenance(msg string) *Maintenance {
+	return &Maintenance{
+		message: msg,
+	}
+}
+
+// Message returns the current maintenance message, or an empty string outside of maintenance.
+func (m *Maintenance) Message() string {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.message
+}
+
+// OnChange registers a function that is called with the new message whenever it changes.
+func (m *Maintenance) OnChange(fn func(msg string)) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.onChange = append(m.onChange, fn)
+}
+
+// Set changes the maintenance message, an empty message ends maintenance.
+func (m *Maintenance) Set(msg string) {
+	m.Lock()
+	if m.message == msg {
+		m.Unlock()
+		return
+	}
+	m.message = msg
+	handlers := m.onChange
+	m.Unlock()
+
+	for _, fn := range handlers {
+		fn(msg)
+	}
+}
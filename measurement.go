@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MEASUREMENT_TEMPERATURE is the name of the temperature, which readings keep in their Temperature
+const MEASUREMENT_TEMPERATURE = "temperature"
+
+// Measurement is one of the quantities measured by a sensor, e.g. the humidity of a temperature and humidity sensor.
+type Measurement struct {
+	// Name identifies the quantity within a reading, e.g. "humidity"
+	Name  string  `json:"name"`
+	Label string  `json:"label"`
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+}
+
+// String formats the value with one decimal and its unit.
+func (m Measurement) String() string {
+	value := strconv.FormatFloat(m.Value, 'f', 1, 64)
+	if r, _ := utf8.DecodeRuneInString(m.Unit); unicode.IsLetter(r) {
+		return value + " " + m.Unit
+	}
+	return value + m.Unit
+}
+
+// monnitDataTypes maps the Monnit data types to the quantities they measure. DataValues are always in the unit
+// given here, unlike PlotValues, which are in the unit chosen in the iMonnit account.
+var monnitDataTypes = map[string]Measurement{
+	"TemperatureData": {Name: MEASUREMENT_TEMPERATURE, Label: "Temperature", Unit: UNIT_CELSIUS},
+	// Relative humidity of the humidity sensors
+	"Percentage":   {Name: "humidity", Label: "Humidity", Unit: "%"},
+	"pH":           {Name: "ph", Label: "pH"},
+	"Conductivity": {Name: "conductivity", Label: "Conductivity", Unit: "µS/cm"},
+	"Voltage":      {Name: "voltage", Label: "Voltage", Unit: "V"},
+}
+
+// ParseMonnitMeasurements parses the pipe separated DataValues, DataTypes and PlotLabels of a Monnit message, e.g.
+// "45.3|22.5", "Percentage|TemperatureData" and "Humidity|Celsius". Unknown data types are named after their plot
+// label. Values that can't be parsed are NaN, and names measured more than once are numbered, e.g. temperature_2.
+func ParseMonnitMeasurements(dataValues, dataTypes, plotLabels string) ([]Measurement, error) {
+	if dataValues == "" {
+		return nil, nil
+	}
+	values := strings.Split(dataValues, "|")
+	types := strings.Split(dataTypes, "|")
+	labels := strings.Split(plotLabels, "|")
+	if len(types) != len(values) {
+		return nil, fmt.Errorf("%d data values but %d data types", len(values), len(types))
+	}
+
+	measurements := make([]Measurement, 0, len(values))
+	seen := make(map[string]int)
+	for i, v := range values {
+		m, ok := monnitDataTypes[types[i]]
+		if !ok {
+			label := types[i]
+			if i < len(labels) && labels[i] != "" {
+				label = labels[i]
+			}
+			m = Measurement{Name: measurementName(label), Label: label}
+		}
+
+		if seen[m.Name]++; seen[m.Name] > 1 {
+			m.Name = fmt.Sprintf("%s_%d", m.Name, seen[m.Name])
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			value = math.NaN()
+		}
+		m.Value = value
+		measurements = append(measurements, m)
+	}
+
+	return measurements, nil
+}
+
+// measurementName turns a label into a name usable in URLs, e.g. "Dissolved Oxygen" into "dissolved_oxygen".
+func measurementName(label string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(label) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "_"):
+			b.WriteByte('_')
+		}
+	}
+	if name := strings.TrimSuffix(b.String(), "_"); name != "" {
+		return name
+	}
+	return "value"
+}
@@ -13,11 +13,22 @@ func (t *MessageDate) String() string {
 	return time.Time(*t).Format("Mon, 02 Jan 2006 15:04:05")
 }
 
+// StringIn returns the MessageDate formatted like [MessageDate.String] in the location. Readings are kept in UTC,
+// so this is what is shown to people.
+func (t *MessageDate) StringIn(location *time.Location) string {
+	local := MessageDate(time.Time(*t).In(location))
+	return local.String()
+}
+
 // UnmarshalJSON parses a .NET datetime that has been serialised into JSON
 // with a shape of "\/Date(1730328597000)\/", representing a UNIX timestamp
-// with milliseconds
+// with milliseconds. RFC3339 dates written by [MessageDate.MarshalJSON] are accepted as well.
 func (t *MessageDate) UnmarshalJSON(b []byte) error {
 	s := string(b)
+	if parsed, err := time.Parse(`"`+time.RFC3339+`"`, s); err == nil {
+		*t = MessageDate(parsed)
+		return nil
+	}
 	s = strings.TrimPrefix(s, `"\/Date(`)
 	s = strings.TrimSuffix(s, `)\/"`)
 	i, err := strconv.ParseInt(s, 10, 64)
@@ -2,10 +2,11 @@ package main
 
 import (
 	"encoding/json"
-	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -20,14 +21,20 @@ type Monnit struct {
 	apiSecretKey string
 	apiUrl       string
 	lastData     *SensorDataMessages
+	nextRefresh  time.Time
+	pushed       bool
+	onNewReading []func(last *SensorDataMessage)
 }
 
-func NewMonnit(sensorID, apiKeyID, apiSecretKey, url string, interval time.Duration) *Monnit {
+// NewMonnit polls the readings of the sensor every interval, if set. pushed tells whether readings are also
+// pushed at any time, by the Monnit webhook or other devices.
+func NewMonnit(sensorID, apiKeyID, apiSecretKey, url string, interval time.Duration, pushed bool) *Monnit {
 	monnit := Monnit{
 		sensorId:     sensorID,
 		apiKeyId:     apiKeyID,
 		apiSecretKey: apiSecretKey,
 		apiUrl:       url,
+		pushed:       pushed,
 	}
 
 	// Load cached data
@@ -35,9 +42,10 @@ func NewMonnit(sensorID, apiKeyID, apiSecretKey, url string, interval time.Durat
 		if err = json.NewDecoder(f).Decode(&monnit.lastData); err != nil {
 			slog.Error("unable to restore cached values", "error", err)
 		}
+		f.Close()
 		slog.Info("loaded cached Monnit data", "cache", CACHE_FILE)
 		slog.Info("latest reading", "measurement", monnit.LastReading())
-	} else {
+	} else if interval > 0 {
 		slog.Info("cached Monnit data not found", "cache", CACHE_FILE)
 		if err = monnit.LoadData(); err != nil {
 			slog.Warn("problem loading data on startup", "error", err)
@@ -45,7 +53,12 @@ func NewMonnit(sensorID, apiKeyID, apiSecretKey, url string, interval time.Durat
 		slog.Info("latest reading", "measurement", monnit.LastReading())
 	}
 
-	go monnit.refresh(interval)
+	// Without polling, readings only arrive through the Monnit webhook
+	if interval > 0 {
+		go monnit.refresh(interval)
+	} else {
+		slog.Info("polling the Monnit API is disabled")
+	}
 
 	return &monnit
 }
@@ -53,7 +66,9 @@ func NewMonnit(sensorID, apiKeyID, apiSecretKey, url string, interval time.Durat
 // refresh automatically updates sensor data at the interval
 func (m *Monnit) refresh(interval time.Duration) {
 	ticker := time.NewTicker(interval)
+	m.setNextRefresh(time.Now().Add(interval))
 	for range ticker.C {
+		m.setNextRefresh(time.Now().Add(interval))
 		err := m.LoadData()
 		if err != nil {
 			slog.Error("failed to load data", "error", err)
@@ -63,7 +78,128 @@ func (m *Monnit) refresh(interval time.Duration) {
 	}
 }
 
+func (m *Monnit) setNextRefresh(next time.Time) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.nextRefresh = next
+}
+
+// NextRefresh returns when the data will next be loaded from the Monnit API, or now if readings are pushed,
+// as a new one may arrive any time.
+func (m *Monnit) NextRefresh() time.Time {
+	m.RLock()
+	defer m.RUnlock()
+
+	if m.pushed {
+		return time.Now()
+	}
+	return m.nextRefresh
+}
+
+// OnNewReading registers a function that is called with the latest reading whenever [Monnit.LoadData] or
+// [Monnit.Ingest] add readings, including older ones filling gaps.
+func (m *Monnit) OnNewReading(fn func(last *SensorDataMessage)) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.onNewReading = append(m.onNewReading, fn)
+}
+
+// LoadData loads the last seven days of readings from the Monnit API and notifies
+// the [Monnit.OnNewReading] handlers if there are readings it didn't have before.
 func (m *Monnit) LoadData() error {
+	added, err := m.loadData()
+
+	// Also when only the cache couldn't be saved
+	if added > 0 {
+		m.notify()
+	}
+	return err
+}
+
+// Ingest merges messages pushed by the Monnit webhook into the readings, the same way as if they had been
+// loaded by [Monnit.LoadData], and returns how many of them were new. Messages of other sensors are ignored.
+func (m *Monnit) Ingest(messages []SensorDataMessage) (int, error) {
+	added, err := m.merge(messages)
+	if added > 0 {
+		m.notify()
+	}
+	return added, err
+}
+
+// notify calls the [Monnit.OnNewReading] handlers with the latest reading.
+func (m *Monnit) notify() {
+	last := m.LastReading()
+
+	m.RLock()
+	handlers := m.onNewReading
+	m.RUnlock()
+
+	for _, fn := range handlers {
+		fn(last)
+	}
+}
+
+// merge adds the messages of the sensor that aren't known yet, keeping the last seven days with the latest first,
+// and updates the cache.
+func (m *Monnit) merge(messages []SensorDataMessage) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	// Always a new slice, as messages handed out by LastReading must not change
+	sdm := SensorDataMessages{
+		Method:      "SensorDataMessages",
+		LastUpdated: time.Now(),
+	}
+	if m.lastData != nil {
+		sdm.Messages = slices.Clone(m.lastData.Messages)
+	}
+	known := make(map[string]bool, len(sdm.Messages))
+	for _, msg := range sdm.Messages {
+		known[msg.DataMessageGUID] = true
+	}
+
+	added := 0
+	for _, msg := range messages {
+		if msg.DataMessageGUID == "" || known[msg.DataMessageGUID] || strconv.Itoa(msg.SensorID) != m.sensorId {
+			continue
+		}
+		known[msg.DataMessageGUID] = true
+		sdm.Messages = append(sdm.Messages, msg)
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+
+	since := time.Now().AddDate(0, 0, -7)
+	sdm.Messages = slices.DeleteFunc(sdm.Messages, func(msg SensorDataMessage) bool {
+		return time.Time(msg.MessageDate).Before(since)
+	})
+	slices.SortStableFunc(sdm.Messages, func(a, b SensorDataMessage) int {
+		return time.Time(b.MessageDate).Compare(time.Time(a.MessageDate))
+	})
+	m.lastData = &sdm
+
+	return added, m.saveCache()
+}
+
+// saveCache writes the readings to the cache, replacing the old one once it is written.
+// Must be called with the lock held.
+func (m *Monnit) saveCache() error {
+	b, err := json.Marshal(m.lastData)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(CACHE_FILE+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(CACHE_FILE+".tmp", CACHE_FILE)
+}
+
+// loadData replaces the readings with the ones loaded from the Monnit API, and returns how many of them are new.
+func (m *Monnit) loadData() (int, error) {
 	m.Lock()
 	defer m.Unlock()
 
@@ -77,7 +213,7 @@ func (m *Monnit) LoadData() error {
 	req, err := http.NewRequest("GET", m.apiUrl, nil)
 	if err != nil {
 		slog.Error("error creating request", "error", err)
-		return err
+		return 0, err
 	}
 
 	// Set API keys in HTTP headers
@@ -101,25 +237,34 @@ func (m *Monnit) LoadData() error {
 	res, err := client.Do(req)
 	if err != nil {
 		slog.Error("error sending request", "error", err)
-		return err
+		return 0, err
 	}
 	defer res.Body.Close()
 
-	// Write cache while reading response body
-	f, _ := os.Create(CACHE_FILE)
-	r := io.TeeReader(res.Body, f)
-
 	// Parse response
-	err = json.NewDecoder(r).Decode(&sdm)
+	err = json.NewDecoder(res.Body).Decode(&sdm)
 	if err != nil {
 		slog.Error("error decoding JSON response", "error", err)
-		return err
+		return 0, err
+	}
+
+	known := make(map[string]bool)
+	if m.lastData != nil {
+		for _, msg := range m.lastData.Messages {
+			known[msg.DataMessageGUID] = true
+		}
+	}
+	added := 0
+	for _, msg := range sdm.Messages {
+		if !known[msg.DataMessageGUID] {
+			added++
+		}
 	}
 
-	// Update data
+	// Update data, only replacing the cache once the response is complete
 	m.lastData = &sdm
 
-	return nil
+	return added, m.saveCache()
 }
 
 func (m *Monnit) LastReading() *SensorDataMessage {
@@ -132,16 +277,15 @@ func (m *Monnit) LastReading() *SensorDataMessage {
 	return m.lastData.GetLast()
 }
 
-// ToApiResponse converts the Monnit's last sensor data messages to an ApiResponse format containing API messages.
-func (m *Monnit) ToApiResponse() ApiResponse {
+// Messages returns a copy of the readings last loaded from Monnit.
+func (m *Monnit) Messages() []SensorDataMessage {
 	m.RLock()
 	defer m.RUnlock()
 
-	var apiMessages []ApiMessage
-	for _, m := range m.lastData.Messages {
-		apiMessages = append(apiMessages, m.ToApiMessage())
+	if m.lastData == nil {
+		return nil
 	}
-	return apiMessages
+	return slices.Clone(m.lastData.Messages)
 }
 
 // SensorDataMessages represents the structure for sensor data communication.
@@ -176,6 +320,23 @@ type SensorDataMessage struct {
 	DataTypes                   string      `json:"DataTypes"`
 	PlotValues                  string      `json:"PlotValues"`
 	PlotLabels                  string      `json:"PlotLabels"`
+
+	// Measurements besides the temperature, set when converted from a [Reading]
+	Measurements []Measurement `json:"-"`
+}
+
+// Measurement returns the measurement with the given name, including the temperature, or false if the sensor
+// didn't measure it.
+func (m *SensorDataMessage) Measurement(name string) (Measurement, bool) {
+	if name == MEASUREMENT_TEMPERATURE {
+		return Measurement{Name: name, Label: "Temperature", Value: float64(m.Temperature), Unit: UNIT_CELSIUS}, true
+	}
+	for _, measurement := range m.Measurements {
+		if measurement.Name == name {
+			return measurement, true
+		}
+	}
+	return Measurement{}, false
 }
 
 func (m SensorDataMessage) LogValue() slog.Value {
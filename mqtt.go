@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	MQTT_QOS     = 1
+	MQTT_TIMEOUT = 10 * time.Second
+
+	MQTT_ONLINE  = "online"
+	MQTT_OFFLINE = "offline"
+)
+
+// MqttState is the retained payload of the state topic, which all Home Assistant entities read their values from.
+type MqttState struct {
+	Temperature    float64   `json:"temperature"`
+	Battery        int       `json:"battery"`
+	SignalStrength int       `json:"signal_strength"`
+	Time           time.Time `json:"time"`
+}
+
+// MqttDiscovery is the Home Assistant MQTT discovery config of a sensor entity.
+// See https://www.home-assistant.io/integrations/sensor.mqtt/
+type MqttDiscovery struct {
+	Name              string           `json:"name"`
+	UniqueId          string           `json:"unique_id"`
+	ObjectId          string           `json:"object_id"`
+	StateTopic        string           `json:"state_topic"`
+	ValueTemplate     string           `json:"value_template"`
+	AvailabilityTopic string           `json:"availability_topic"`
+	DeviceClass       string           `json:"device_class,omitempty"`
+	StateClass        string           `json:"state_class,omitempty"`
+	Unit              string           `json:"unit_of_measurement,omitempty"`
+	EntityCategory    string           `json:"entity_category,omitempty"`
+	Device            MqttDeviceConfig `json:"device"`
+}
+
+type MqttDeviceConfig struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// MqttPublisher publishes every new reading to an MQTT broker as a retained message, and announces the
+// temperature, battery and signal strength as sensors to Home Assistant.
+type MqttPublisher struct {
+	sync.Mutex
+	client          mqtt.Client
+	topic           string
+	discoveryPrefix string
+	deviceId        string
+	deviceName      string
+	readings        *Readings
+
+	// next is the latest reading not published yet, published one at a time when signalled on queued
+	next   *SensorDataMessage
+	queued chan struct{}
+}
+
+// NewMqttPublisher connects to the broker set by MQTT_BROKER in the background, retrying until it succeeds.
+// On every (re)connect the discovery configs and the latest reading are published.
+func NewMqttPublisher(cfg *Config, readings *Readings) *MqttPublisher {
+	p := MqttPublisher{
+		topic:           cfg.MqttTopic,
+		discoveryPrefix: cfg.MqttDiscoveryPrefix,
+		deviceId:        "spt_" + cfg.SensorId,
+		deviceName:      cfg.LocationName,
+		readings:        readings,
+		queued:          make(chan struct{}, 1),
+	}
+	go p.run()
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.MqttBroker).
+		SetClientID(cfg.MqttClientId).
+		SetUsername(cfg.MqttUsername).
+		SetPassword(cfg.MqttPassword).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(p.availabilityTopic(), MQTT_OFFLINE, MQTT_QOS, true).
+		SetOnConnectHandler(p.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("lost connection to MQTT broker", "error", err)
+		})
+	p.client = mqtt.NewClient(opts)
+	p.client.Connect()
+
+	return &p
+}
+
+func (p *MqttPublisher) stateTopic() string {
+	return p.topic + "/state"
+}
+
+func (p *MqttPublisher) availabilityTopic() string {
+	return p.topic + "/availability"
+}
+
+func (p *MqttPublisher) onConnect(mqtt.Client) {
+	slog.Info("connected to MQTT broker", "topic", p.topic)
+
+	// Publishing waits for the broker, which must not block the client calling this handler
+	go func() {
+		for _, d := range p.discoveries() {
+			topic := fmt.Sprintf("%s/sensor/%s/config", p.discoveryPrefix, d.UniqueId)
+			if err := p.publishJSON(topic, d); err != nil {
+				slog.Error("unable to publish MQTT discovery config", "topic", topic, "error", err)
+			}
+		}
+		if err := p.publish(p.availabilityTopic(), MQTT_ONLINE); err != nil {
+			slog.Error("unable to publish MQTT availability", "error", err)
+		}
+		if last := p.readings.LastReading(); last.DataMessageGUID != "" {
+			p.Publish(last)
+		}
+	}()
+}
+
+// discoveries returns the discovery configs of the sensor entities, all belonging to the same device.
+func (p *MqttPublisher) discoveries() []MqttDiscovery {
+	device := MqttDeviceConfig{
+		Identifiers:  []string{p.deviceId},
+		Name:         p.deviceName,
+		Manufacturer: "Monnit",
+		Model:        "Wireless temperature sensor",
+	}
+	sensor := func(key, name string) MqttDiscovery {
+		return MqttDiscovery{
+			Name:              name,
+			UniqueId:          p.deviceId + "_" + key,
+			ObjectId:          p.deviceId + "_" + key,
+			StateTopic:        p.stateTopic(),
+			ValueTemplate:     fmt.Sprintf("{{ value_json.%s }}", key),
+			AvailabilityTopic: p.availabilityTopic(),
+			StateClass:        "measurement",
+			Device:            device,
+		}
+	}
+
+	temperature := sensor("temperature", "Temperature")
+	temperature.DeviceClass = "temperature"
+	temperature.Unit = UNIT_CELSIUS
+
+	battery := sensor("battery", "Battery")
+	battery.DeviceClass = "battery"
+	battery.Unit = "%"
+	battery.EntityCategory = "diagnostic"
+
+	signal := sensor("signal_strength", "Signal strength")
+	signal.Unit = "%"
+	signal.EntityCategory = "diagnostic"
+
+	return []MqttDiscovery{temperature, battery, signal}
+}
+
+// Publish queues the reading to be sent to the state topic without waiting for the broker. Readings are sent
+// in the order they are queued, so the retained one is always the latest. A reading still waiting is replaced.
+func (p *MqttPublisher) Publish(last *SensorDataMessage) {
+	p.Lock()
+	p.next = last
+	p.Unlock()
+
+	select {
+	case p.queued <- struct{}{}:
+	default:
+	}
+}
+
+// run publishes the queued readings.
+func (p *MqttPublisher) run() {
+	for range p.queued {
+		p.Lock()
+		last := p.next
+		p.next = nil
+		p.Unlock()
+
+		if last != nil {
+			p.publishState(last)
+		}
+	}
+}
+
+// publishState sends the reading to the state topic, retained so new subscribers get it straight away.
+func (p *MqttPublisher) publishState(last *SensorDataMessage) {
+	state := MqttState{
+		Temperature:    float64(last.Temperature),
+		Battery:        last.Battery,
+		SignalStrength: last.SignalStrength,
+		Time:           time.Time(last.MessageDate).UTC(),
+	}
+	if err := p.publishJSON(p.stateTopic(), state); err != nil {
+		slog.Error("unable to publish reading to MQTT", "error", err)
+		return
+	}
+	slog.Debug("published reading to MQTT", "topic", p.stateTopic(), "measurement", last)
+}
+
+func (p *MqttPublisher) publishJSON(topic string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return p.publish(topic, b)
+}
+
+func (p *MqttPublisher) publish(topic string, payload any) error {
+	token := p.client.Publish(topic, MQTT_QOS, true, payload)
+	if !token.WaitTimeout(MQTT_TIMEOUT) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	return token.Error()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// startBroker starts an in-process MQTT broker on a free port and returns its address.
+func startBroker(t *testing.T) (*mqttserver.Server, string) {
+	t.Helper()
+
+	server := mqttserver.New(&mqttserver.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	tcp := listeners.NewTCP(listeners.Config{ID: "test", Address: "127.0.0.1:0"})
+	if err := server.AddListener(tcp); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	return server, "tcp://" + tcp.Address()
+}
+
+// retained waits for the retained message of the topic to satisfy ok, and returns it.
+func retained(t *testing.T, server *mqttserver.Server, topic string, ok func(packets.Packet) bool) packets.Packet {
+	t.Helper()
+
+	deadline := time.Now().Add(MQTT_TIMEOUT)
+	for time.Now().Before(deadline) {
+		for _, pk := range server.Topics.Messages(topic) {
+			if pk.TopicName == topic && ok(pk) {
+				return pk
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no retained message on %s", topic)
+	return packets.Packet{}
+}
+
+// anyMessage accepts the first retained message.
+func anyMessage(packets.Packet) bool { return true }
+
+func TestMqttPublisher(t *testing.T) {
+	server, broker := startBroker(t)
+
+	history, err := NewHistory(filepath.Join(t.TempDir(), "history.ndjson"), Validator{MinTemperature: -2, MaxTemperature: 35}, &Calibrations{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readingTime := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	if _, err = history.AddReadings([]Reading{{GUID: "first", Time: readingTime, Temperature: 16.4, Battery: 92, SignalStrength: 75, Source: SOURCE_MONNIT}}); err != nil {
+		t.Fatal(err)
+	}
+	readings := NewReadings(history, SOURCE_MODE_LATEST, nil, time.Hour)
+
+	cfg := &Config{
+		SensorId:            "123456",
+		LocationName:        "Bude Sea Pool",
+		MqttBroker:          broker,
+		MqttClientId:        "spt-test",
+		MqttTopic:           "spt",
+		MqttDiscoveryPrefix: "homeassistant",
+	}
+	p := NewMqttPublisher(cfg, readings)
+	t.Cleanup(func() { p.client.Disconnect(250) })
+
+	// Published on connect
+	state := retained(t, server, "spt/state", anyMessage)
+	var got MqttState
+	if err = json.Unmarshal(state.Payload, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := MqttState{Temperature: 16.4, Battery: 92, SignalStrength: 75, Time: readingTime}
+	if got != want {
+		t.Errorf("state = %+v, want %+v", got, want)
+	}
+
+	availability := retained(t, server, "spt/availability", anyMessage)
+	if string(availability.Payload) != MQTT_ONLINE {
+		t.Errorf("availability = %q, want %q", availability.Payload, MQTT_ONLINE)
+	}
+
+	for _, tc := range []struct {
+		key, deviceClass, unit, entityCategory string
+	}{
+		{"temperature", "temperature", UNIT_CELSIUS, ""},
+		{"battery", "battery", "%", "diagnostic"},
+		{"signal_strength", "", "%", "diagnostic"},
+	} {
+		topic := "homeassistant/sensor/spt_123456_" + tc.key + "/config"
+		var d MqttDiscovery
+		if err = json.Unmarshal(retained(t, server, topic, anyMessage).Payload, &d); err != nil {
+			t.Fatal(err)
+		}
+		if d.UniqueId != "spt_123456_"+tc.key || d.StateTopic != "spt/state" || d.AvailabilityTopic != "spt/availability" {
+			t.Errorf("%s: topics and id = %s, %s, %s", topic, d.UniqueId, d.StateTopic, d.AvailabilityTopic)
+		}
+		if d.ValueTemplate != "{{ value_json."+tc.key+" }}" {
+			t.Errorf("%s: value template = %q", topic, d.ValueTemplate)
+		}
+		if d.DeviceClass != tc.deviceClass || d.Unit != tc.unit || d.EntityCategory != tc.entityCategory {
+			t.Errorf("%s: device class, unit and category = %q, %q, %q", topic, d.DeviceClass, d.Unit, d.EntityCategory)
+		}
+		if len(d.Device.Identifiers) != 1 || d.Device.Identifiers[0] != "spt_123456" || d.Device.Name != "Bude Sea Pool" {
+			t.Errorf("%s: device = %+v", topic, d.Device)
+		}
+	}
+
+	// New readings replace the retained state, in the order they are published
+	var last *SensorDataMessage
+	for i := 1; i <= 20; i++ {
+		last = &SensorDataMessage{DataMessageGUID: fmt.Sprintf("reading-%d", i), MessageDate: MessageDate(readingTime.Add(time.Duration(i) * 10 * time.Minute)), Temperature: Temperature(16 + float64(i)/10), Battery: 91, SignalStrength: 70}
+		p.Publish(last)
+	}
+	retained(t, server, "spt/state", func(pk packets.Packet) bool {
+		return json.Unmarshal(pk.Payload, &got) == nil && got.Temperature == 18
+	})
+	if want = (MqttState{Temperature: 18, Battery: 91, SignalStrength: 70, Time: time.Time(last.MessageDate)}); got != want {
+		t.Errorf("state = %+v, want %+v", got, want)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err = json.Unmarshal(retained(t, server, "spt/state", anyMessage).Payload, &got); err != nil || got != want {
+		t.Errorf("state = %+v after the last reading was published, want %+v", got, want)
+	}
+}
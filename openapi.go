@@ -0,0 +1,223 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OpenApiDocument returns the OpenAPI 3 description of the public API. Schemas are generated from
+// the API types, so the document can't drift from what the endpoints actually return.
+func OpenApiDocument() map[string]any {
+	rateLimitHeaders := map[string]any{
+		"RateLimit-Limit":     header("Requests allowed in a burst"),
+		"RateLimit-Remaining": header("Requests remaining in the current burst"),
+		"RateLimit-Reset":     header("Seconds until the burst is fully available again"),
+	}
+	errorResponse := func(description string) map[string]any {
+		return map[string]any{
+			"description": description,
+			"headers":     rateLimitHeaders,
+			"content":     jsonContent(ref("ApiError")),
+		}
+	}
+	rangeParameters := []any{
+		query("from", "Start of the range, an RFC 3339 time or a local date. Defaults to seven days ago."),
+		query("to", "End of the range, an RFC 3339 time or a local date, which includes the whole day. Defaults to now."),
+		query("source", "Comma separated list of the sources of the readings, e.g. monnit. Defaults to all sources."),
+	}
+	exportParameters := slices.Concat(rangeParameters, []any{
+		query("include", "Additional columns, a comma separated list of raw, battery, signal and source."),
+	})
+	get := func(summary, description string, parameters []any, content map[string]any) map[string]any {
+		operation := map[string]any{
+			"summary":     summary,
+			"description": description,
+			"security":    []any{map[string]any{}, map[string]any{"apiKey": []any{}}},
+			"responses": map[string]any{
+				"200": map[string]any{
+					"description": "OK",
+					"headers":     rateLimitHeaders,
+					"content":     content,
+				},
+				"304": map[string]any{"description": "Not modified since the ETag or date sent"},
+				"401": errorResponse("Invalid API key"),
+				"429": errorResponse("Rate limit exceeded"),
+			},
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		return map[string]any{"get": operation}
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Bude Seapool Temperature",
+			"description": "Temperatures measured by the sensor in Bude Sea Pool.",
+			"version":     "1.0.0",
+		},
+		"servers": []any{map[string]any{"url": "/"}},
+		"paths": map[string]any{
+			"/api/v1/temperature": get("Latest reading",
+				"The latest temperature reading.", nil, jsonContent(ref("ApiMessage"))),
+			"/api/v1/temperatures": get("List of last readings",
+				"The readings of the last seven days, or of the requested range, latest first.",
+				rangeParameters, jsonContent(ref("ApiResponse"))),
+			"/api/v1/conditions": get("Swimming conditions",
+				"The swimming condition of the latest reading, and all condition bands.", nil,
+				jsonContent(ref("ApiConditions"))),
+			"/api/v1/temperatures.csv": get("CSV export",
+				"The readings of the requested range, oldest first.", exportParameters,
+				map[string]any{"text/csv": map[string]any{"schema": map[string]any{"type": "string"}}}),
+			"/api/v1/temperatures.ndjson": get("NDJSON export",
+				"The readings of the requested range, oldest first, one JSON object per line.", exportParameters,
+				map[string]any{"application/x-ndjson": map[string]any{"schema": ref("ExportReading")}}),
+			"/api/v1/temperatures.xlsx": get("Excel export",
+				"The readings of the requested range, oldest first, as spreadsheet.", exportParameters,
+				map[string]any{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": map[string]any{
+					"schema": map[string]any{"type": "string", "format": "binary"},
+				}}),
+			"/api/v2/temperature": get("Latest reading with metadata",
+				"The latest reading, or none if there is no reading yet, with metadata about the sensor and staleness.",
+				nil, jsonContent(ref("ApiV2Response"))),
+			"/api/v2/temperatures": get("Readings with metadata",
+				"The readings of the last seven days, or of the requested range, latest first, with metadata.",
+				rangeParameters, jsonContent(ref("ApiV2Response"))),
+			"/api/v1/readings": map[string]any{
+				"post": map[string]any{
+					"summary": "Post readings",
+					"description": "Stores a reading, or an array of readings, of a device other than the Monnit sensor. " +
+						"Nothing is stored if any reading is invalid.",
+					"security": []any{map[string]any{"deviceToken": []any{}}},
+					"requestBody": map[string]any{
+						"required": true,
+						"content": jsonContent(map[string]any{
+							"oneOf": []any{ref("IngestReading"), map[string]any{"type": "array", "items": ref("IngestReading")}},
+						}),
+					},
+					"responses": map[string]any{
+						"201": map[string]any{"description": "Stored", "content": jsonContent(ref("IngestResponse"))},
+						"400": errorResponse("Invalid reading"),
+						"401": errorResponse("Invalid device token"),
+					},
+				},
+			},
+			"/api/v1/stream": map[string]any{
+				"get": map[string]any{
+					"summary": "Live updates",
+					"description": "Server-Sent Events with a `reading` event carrying an ApiMessage whenever a new " +
+						"reading arrives, and a `maintenance` event whenever the maintenance message changes.",
+					"responses": map[string]any{
+						"200": map[string]any{
+							"description": "Event stream",
+							"content": map[string]any{
+								"text/event-stream": map[string]any{"schema": map[string]any{"type": "string"}},
+							},
+						},
+					},
+				},
+			},
+		},
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"ApiMessage":     JsonSchema(reflect.TypeFor[ApiMessage]()),
+				"ApiResponse":    JsonSchema(reflect.TypeFor[ApiResponse]()),
+				"ApiError":       JsonSchema(reflect.TypeFor[ApiError]()),
+				"ApiConditions":  JsonSchema(reflect.TypeFor[ApiConditions]()),
+				"ExportReading":  JsonSchema(reflect.TypeFor[ExportReading]()),
+				"ApiV2Response":  JsonSchema(reflect.TypeFor[ApiV2Response]()),
+				"IngestReading":  JsonSchema(reflect.TypeFor[IngestReading]()),
+				"IngestResponse": JsonSchema(reflect.TypeFor[IngestResponse]()),
+			},
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{
+					"type": "apiKey",
+					"in":   "header",
+					"name": API_KEY_HEADER,
+				},
+				"deviceToken": map[string]any{
+					"type":   "http",
+					"scheme": "bearer",
+				},
+			},
+		},
+	}
+}
+
+// JsonSchema generates the OpenAPI schema of a type from its fields and their json tags.
+func JsonSchema(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeFor[Temperature]():
+		return map[string]any{"type": "number", "format": "float", "description": "Degrees Celsius", "example": 13.4}
+	case reflect.TypeFor[Color]():
+		return map[string]any{"type": "string", "example": "#1c7ed6"}
+	case reflect.TypeFor[MessageDate](), reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time", "example": "2024-11-07T22:30:00Z"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return JsonSchema(t.Elem())
+	case reflect.Struct:
+		properties := make(map[string]any)
+		var required []string
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = JsonSchema(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": JsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": JsonSchema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+
+	return map[string]any{}
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+func query(name, description string) map[string]any {
+	return map[string]any{
+		"name":        name,
+		"in":          "query",
+		"description": description,
+		"schema":      map[string]any{"type": "string"},
+	}
+}
+
+func header(description string) map[string]any {
+	return map[string]any{"description": description, "schema": map[string]any{"type": "integer"}}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/subtle"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const API_KEY_HEADER = "X-API-Key"
+
+// RateLimiter is a token bucket rate limiter per key. Each bucket holds up to burst tokens and is
+// refilled with limit tokens per minute. Every request takes one token.
+type RateLimiter struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(limit, burst int) *RateLimiter {
+	rl := RateLimiter{
+		rate:    float64(limit) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+
+	go rl.cleanup(time.Minute)
+
+	return &rl
+}
+
+// cleanup periodically removes buckets that are full again, which behave the same as new ones.
+func (rl *RateLimiter) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for now := range ticker.C {
+		rl.Lock()
+		for key, b := range rl.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+				delete(rl.buckets, key)
+			}
+		}
+		rl.Unlock()
+	}
+}
+
+// Allow takes a token from the bucket of the key. It returns whether the request is allowed, the
+// tokens remaining and how long until the bucket is full again, or until the next token if it's empty.
+func (rl *RateLimiter) Allow(key string) (bool, int, time.Duration) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	now := time.Now()
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, 0, rl.until(1 - b.tokens)
+	}
+	b.tokens--
+
+	return true, int(b.tokens), rl.until(rl.burst - b.tokens)
+}
+
+// until returns how long it takes to refill the given number of tokens.
+func (rl *RateLimiter) until(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / rl.rate * float64(time.Second)))
+}
+
+// RateLimit limits requests per client IP, or per API key if a known one is sent in the X-API-Key header
+// or api_key query parameter. Requests with API keys use the keyLimiter and are counted in the state.
+// Unknown API keys are rejected, after taking a token of the client IP, so guessing keys is throttled too.
+func RateLimit(limiter, keyLimiter *RateLimiter, apiKeys map[string]string, sm *StateManager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		l, key := limiter, "ip:"+c.IP()
+
+		invalidKey := false
+		if apiKey := c.Get(API_KEY_HEADER, c.Query("api_key")); apiKey != "" {
+			if name, ok := apiKeyName(apiKeys, apiKey); ok {
+				l, key = keyLimiter, "key:"+name
+				sm.IncrementApiKeyRequests(name)
+			} else {
+				invalidKey = true
+			}
+		}
+
+		allowed, remaining, reset := l.Allow(key)
+		c.Set("RateLimit-Limit", strconv.Itoa(int(l.burst)))
+		c.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+
+		if !allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+			return c.Status(fiber.StatusTooManyRequests).JSON(ApiError{Error: "rate limit exceeded"})
+		}
+		if invalidKey {
+			return c.Status(fiber.StatusUnauthorized).JSON(ApiError{Error: "invalid API key"})
+		}
+
+		return c.Next()
+	}
+}
+
+// apiKeyName returns the name configured for the API key.
+func apiKeyName(apiKeys map[string]string, apiKey string) (string, bool) {
+	for name, key := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
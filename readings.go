@@ -0,0 +1,117 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// SOURCE_MODE_PREFER uses the first source of the priority list with a current reading
+	SOURCE_MODE_PREFER = "prefer"
+
+	// SOURCE_MODE_LATEST uses the latest reading of any source
+	SOURCE_MODE_LATEST = "latest"
+)
+
+// Readings selects the current reading shown on the images and returned by the API from the latest
+// readings of all sources in the history.
+type Readings struct {
+	sync.RWMutex
+	updating     sync.Mutex
+	history      *History
+	mode         string
+	priority     []string
+	staleAfter   time.Duration
+	current      Reading
+	last         *SensorDataMessage
+	onNewReading []func(last *SensorDataMessage)
+}
+
+// NewReadings selects the current reading according to the mode. In [SOURCE_MODE_PREFER], readings of the
+// sources in priority are used in that order, unless they are older than staleAfter.
+func NewReadings(history *History, mode string, priority []string, staleAfter time.Duration) *Readings {
+	r := Readings{
+		history:    history,
+		mode:       mode,
+		priority:   priority,
+		staleAfter: staleAfter,
+		last:       &SensorDataMessage{},
+	}
+	if reading, ok := r.selectReading(time.Now()); ok {
+		r.current = reading
+		r.last = reading.ToSensorDataMessage()
+	}
+
+	return &r
+}
+
+// selectReading returns the current reading according to the mode, or false if there are no readings.
+// Without a current reading of the preferred sources, the latest one is used.
+func (r *Readings) selectReading(now time.Time) (Reading, bool) {
+	latest := r.history.Latest()
+	if r.mode == SOURCE_MODE_PREFER {
+		for _, source := range r.priority {
+			if reading, ok := latest[source]; ok && now.Sub(reading.Time) <= r.staleAfter {
+				return reading, true
+			}
+		}
+	}
+
+	var last Reading
+	for _, reading := range latest {
+		if reading.Time.After(last.Time) {
+			last = reading
+		}
+	}
+	return last, !last.Time.IsZero()
+}
+
+// LastReading returns the current reading, which is empty if there is none.
+func (r *Readings) LastReading() *SensorDataMessage {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.last
+}
+
+// Current returns the current reading with its source, which is empty if there is none.
+func (r *Readings) Current() Reading {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.current
+}
+
+// OnNewReading registers a function that is called whenever the current reading changes.
+func (r *Readings) OnNewReading(fn func(last *SensorDataMessage)) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.onNewReading = append(r.onNewReading, fn)
+}
+
+// Update selects the current reading again after readings were added to the history or recalibrated, and
+// notifies the [Readings.OnNewReading] handlers if it has changed.
+func (r *Readings) Update() {
+	// Handlers are called in the order readings are selected
+	r.updating.Lock()
+	defer r.updating.Unlock()
+
+	reading, ok := r.selectReading(time.Now())
+
+	r.Lock()
+	if !ok || (reading.GUID == r.current.GUID && reading.Temperature == r.current.Temperature) {
+		r.Unlock()
+		return
+	}
+	r.current = reading
+	r.last = reading.ToSensorDataMessage()
+	last := r.last
+	handlers := slices.Clone(r.onNewReading)
+	r.Unlock()
+
+	for _, fn := range handlers {
+		fn(last)
+	}
+}
@@ -1,20 +1,72 @@
 package main
 
 import (
+	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
 
 // State represents the state of the application
 type State struct {
-	LastRequest   time.Time
-	ImageRedraws  int
-	ImageRequests int
-	BotRequests   int
+	LastRequest   time.Time `json:"last_request"`
+	ImageRedraws  int       `json:"image_redraws"`
+	ImageRequests int       `json:"image_requests"`
+	BotRequests   int       `json:"bot_requests"`
+
+	// ApiKeyRequests counts API requests per API key name
+	ApiKeyRequests map[string]int `json:"api_key_requests"`
+
+	// Announcements of maintenance starting and ending, oldest first
+	Announcements []Announcement `json:"announcements"`
+
+	// Analytics of the requests per day, oldest first
+	Analytics []DailyAnalytics `json:"analytics"`
+}
+
+const (
+	// MAX_ANNOUNCEMENTS is how many announcements are kept
+	MAX_ANNOUNCEMENTS = 50
+
+	// MAX_ANALYTICS_DAYS is how many days of request analytics are kept
+	MAX_ANALYTICS_DAYS = 90
+)
+
+// Announcement records a change of the maintenance message, an empty message means maintenance ended.
+type Announcement struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// DailyAnalytics sums up the requests of a day in the local time zone.
+type DailyAnalytics struct {
+	// Date as YYYY-MM-DD
+	Date     string `json:"date"`
+	Requests int    `json:"requests"`
+	// Requests per client class, bot, signage, browser or other
+	Clients map[string]int `json:"clients"`
+	// Requests per route, e.g. "GET /api/v1/temperature"
+	Routes map[string]int `json:"routes"`
+	// Image requests per image type
+	Images       map[string]int `json:"images"`
+	ImageRedraws int            `json:"image_redraws"`
+}
+
+// clone returns a copy that doesn't share the maps.
+func (d DailyAnalytics) clone() DailyAnalytics {
+	d.Clients = maps.Clone(d.Clients)
+	d.Routes = maps.Clone(d.Routes)
+	d.Images = maps.Clone(d.Images)
+	return d
 }
 
 func (s State) LogValue() slog.Value {
@@ -22,9 +74,177 @@ func (s State) LogValue() slog.Value {
 		slog.Time("last_request", s.LastRequest),
 		slog.Int("image_redraws", s.ImageRedraws),
 		slog.Int("image_requests", s.ImageRequests),
+		slog.Any("api_key_requests", s.ApiKeyRequests),
 	)
 }
 
+// STATE_VERSION is the version of the state saved, older versions are migrated by [stateMigrations] when loaded
+const STATE_VERSION = 1
+
+// LEGACY_STATE_FILE is the gob state file of earlier releases, which is migrated if there is no state file yet
+const LEGACY_STATE_FILE = "state.gob"
+
+var ErrStateVersion = errors.New("state was saved by a newer release")
+
+// stateFile is the JSON saved to the state file, the state with the version of its format.
+type stateFile struct {
+	Version int             `json:"version"`
+	SavedAt time.Time       `json:"saved_at"`
+	State   json.RawMessage `json:"state"`
+}
+
+// stateMigrations upgrade the state from the version of their index to the next one. Version 0 is the gob
+// state saved by earlier releases.
+var stateMigrations = []func(b []byte) ([]byte, error){
+	migrateGobState,
+}
+
+// migrateGobState converts the gob state of earlier releases to version 1.
+func migrateGobState(b []byte) ([]byte, error) {
+	// The fields as they were saved, so this keeps working when State changes
+	var state struct {
+		LastRequest    time.Time
+		ImageRedraws   int
+		ImageRequests  int
+		BotRequests    int
+		ApiKeyRequests map[string]int
+		Announcements  []struct {
+			Time    time.Time
+			Message string
+		}
+	}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&state); err != nil {
+		return nil, fmt.Errorf("neither a JSON nor a gob state: %w", err)
+	}
+
+	migrated := State{
+		LastRequest:    state.LastRequest,
+		ImageRedraws:   state.ImageRedraws,
+		ImageRequests:  state.ImageRequests,
+		BotRequests:    state.BotRequests,
+		ApiKeyRequests: state.ApiKeyRequests,
+	}
+	for _, a := range state.Announcements {
+		migrated.Announcements = append(migrated.Announcements, Announcement{Time: a.Time, Message: a.Message})
+	}
+	return json.Marshal(migrated)
+}
+
+// readState reads the state file, migrating it from older versions.
+func readState(filename string) (*State, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var file stateFile
+	if err = json.Unmarshal(b, &file); err != nil {
+		// Earlier releases saved the state as gob
+		file = stateFile{Version: 0, State: b}
+	} else if file.Version < 1 {
+		return nil, errors.New("state has no version")
+	}
+	if file.Version > STATE_VERSION {
+		return nil, fmt.Errorf("%w: version %d, expected up to %d", ErrStateVersion, file.Version, STATE_VERSION)
+	}
+
+	payload := []byte(file.State)
+	for version := file.Version; version < STATE_VERSION; version++ {
+		if payload, err = stateMigrations[version](payload); err != nil {
+			return nil, err
+		}
+		slog.Info("migrated state", "filename", filename, "version", version+1)
+	}
+
+	var state State
+	if err = json.Unmarshal(payload, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// LoadState loads the state from the file. A corrupt state file is moved aside to <filename>.corrupt and the
+// backup loaded instead. Without state file and backup, the [LEGACY_STATE_FILE] next to it is migrated if it
+// exists, otherwise the state is empty.
+func LoadState(filename string) (*State, error) {
+	state, err := readState(filename)
+	if err == nil {
+		return state, nil
+	}
+	if errors.Is(err, ErrStateVersion) {
+		return nil, err
+	}
+	// Saving is interrupted between replacing the backup and the state file, so the backup is checked even if
+	// there is no state file
+	if !errors.Is(err, os.ErrNotExist) {
+		slog.Error("state is corrupt, restoring the backup", "filename", filename, "error", err)
+		if err = os.Rename(filename, filename+".corrupt"); err != nil {
+			return nil, err
+		}
+	}
+
+	state, err = readState(filename + ".bak")
+	if err == nil {
+		slog.Warn("restored state from backup", "filename", filename+".bak")
+		return state, nil
+	}
+	if errors.Is(err, ErrStateVersion) {
+		return nil, err
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		slog.Error("backup of the state is corrupt too, starting with an empty state", "filename", filename+".bak", "error", err)
+		return &State{}, nil
+	}
+
+	legacy := filepath.Join(filepath.Dir(filename), LEGACY_STATE_FILE)
+	if legacy == filename {
+		return &State{}, nil
+	}
+	state, err = readState(legacy)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{}, nil
+	}
+	if err != nil {
+		slog.Error("unable to migrate the legacy state, starting with an empty state", "filename", legacy, "error", err)
+		return &State{}, nil
+	}
+	slog.Info("migrated legacy state", "from", legacy, "to", filename)
+	return state, nil
+}
+
+// SaveState writes the state to a new file, which replaces the state file once it is on disk. The previous state
+// file is kept as <filename>.bak to recover from.
+func SaveState(filename string, state *State) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(stateFile{Version: STATE_VERSION, SavedAt: time.Now().UTC(), State: payload}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(filename, filename+".bak"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
 // StateManager handles synchronization and persistence of application state.
 type StateManager struct {
 	sync.Mutex
@@ -32,18 +252,19 @@ type StateManager struct {
 	filename string
 }
 
+// NewStateManager loads the state from the file and saves it at the interval from then on.
 func NewStateManager(filename string, interval time.Duration) (*StateManager, error) {
 	sm := StateManager{
 		state:    &State{},
 		filename: filename,
 	}
 
-	go sm.autoSave(interval)
-
 	if err := sm.Load(); err != nil {
 		return &sm, err
 	}
 
+	go sm.autoSave(interval)
+
 	return &sm, nil
 }
 
@@ -59,42 +280,25 @@ func (sm *StateManager) autoSave(interval time.Duration) {
 	}
 }
 
+// Load replaces the state with the one loaded by [LoadState].
 func (sm *StateManager) Load() error {
-	sm.Lock()
-	defer sm.Unlock()
-
-	f, err := os.Open(sm.filename)
-	// If the state file doesn't exist, save empty state
-	if errors.Is(err, os.ErrNotExist) {
-		return sm.save()
-	}
-
-	if err = gob.NewDecoder(f).Decode(&sm.state); err != nil {
-		slog.Error("failed to decode state", "error", err)
-		err = os.Remove(sm.filename)
-		if err != nil {
-			return err
-		}
-		return sm.save()
-	}
-
-	return nil
-}
-
-func (sm *StateManager) save() error {
-	f, err := os.Create(sm.filename)
+	state, err := LoadState(sm.filename)
 	if err != nil {
 		return err
 	}
 
-	return gob.NewEncoder(f).Encode(sm.state)
+	sm.Lock()
+	defer sm.Unlock()
+	sm.state = state
+
+	return nil
 }
 
 func (sm *StateManager) Save() error {
 	sm.Lock()
 	defer sm.Unlock()
 
-	return sm.save()
+	return SaveState(sm.filename, sm.state)
 }
 
 func (sm *StateManager) SetLastRequest(dt time.Time) {
@@ -103,10 +307,12 @@ func (sm *StateManager) SetLastRequest(dt time.Time) {
 	sm.state.LastRequest = dt
 }
 
-func (sm *StateManager) IncrementImageRedraws() {
+// IncrementImageRedraws counts an image being rendered, in total and on the date.
+func (sm *StateManager) IncrementImageRedraws(date string) {
 	sm.Lock()
 	defer sm.Unlock()
 	sm.state.ImageRedraws++
+	sm.day(date).ImageRedraws++
 }
 
 func (sm *StateManager) IncrementImageRequests() {
@@ -120,3 +326,126 @@ func (sm *StateManager) IncrementBotRequests() {
 	defer sm.Unlock()
 	sm.state.BotRequests++
 }
+
+func (sm *StateManager) IncrementApiKeyRequests(name string) {
+	sm.Lock()
+	defer sm.Unlock()
+	if sm.state.ApiKeyRequests == nil {
+		sm.state.ApiKeyRequests = make(map[string]int)
+	}
+	sm.state.ApiKeyRequests[name]++
+}
+
+// ApiKeyRequests returns a copy of the API request counts per API key name.
+func (sm *StateManager) ApiKeyRequests() map[string]int {
+	sm.Lock()
+	defer sm.Unlock()
+	return maps.Clone(sm.state.ApiKeyRequests)
+}
+
+// AnnounceMaintenance records the maintenance message, unless it is the same as the last one recorded.
+func (sm *StateManager) AnnounceMaintenance(msg string, t time.Time) {
+	sm.Lock()
+	defer sm.Unlock()
+
+	announcements := sm.state.Announcements
+	if len(announcements) > 0 && announcements[len(announcements)-1].Message == msg {
+		return
+	}
+	// Nothing to announce for a service that has never been in maintenance
+	if len(announcements) == 0 && msg == "" {
+		return
+	}
+
+	announcements = append(announcements, Announcement{Time: t, Message: msg})
+	if len(announcements) > MAX_ANNOUNCEMENTS {
+		announcements = announcements[len(announcements)-MAX_ANNOUNCEMENTS:]
+	}
+	sm.state.Announcements = announcements
+}
+
+// Announcements returns a copy of the maintenance announcements, oldest first.
+func (sm *StateManager) Announcements() []Announcement {
+	sm.Lock()
+	defer sm.Unlock()
+	return slices.Clone(sm.state.Announcements)
+}
+
+// RecordRequest counts a request of the client class to the route in the analytics of the date. image is the type of
+// the image requested, if any.
+func (sm *StateManager) RecordRequest(date, client, route, image string) {
+	sm.Lock()
+	defer sm.Unlock()
+
+	d := sm.day(date)
+	d.Requests++
+	d.Clients[client]++
+	d.Routes[route]++
+	if image != "" {
+		d.Images[image]++
+	}
+}
+
+// day returns the analytics of the date, adding the date if it has none yet and dropping the oldest days
+// beyond [MAX_ANALYTICS_DAYS]. Must be called with the lock held.
+func (sm *StateManager) day(date string) *DailyAnalytics {
+	days := sm.state.Analytics
+	i, found := slices.BinarySearchFunc(days, date, func(d DailyAnalytics, date string) int {
+		return strings.Compare(d.Date, date)
+	})
+	if !found {
+		days = slices.Insert(days, i, DailyAnalytics{Date: date})
+		if drop := len(days) - MAX_ANALYTICS_DAYS; drop > 0 {
+			days = days[drop:]
+			// Only if the clock went back further than all days kept
+			i = max(i-drop, 0)
+		}
+		sm.state.Analytics = days
+	}
+
+	d := &days[i]
+	// Maps are missing in days edited to be empty
+	if d.Clients == nil {
+		d.Clients = make(map[string]int)
+	}
+	if d.Routes == nil {
+		d.Routes = make(map[string]int)
+	}
+	if d.Images == nil {
+		d.Images = make(map[string]int)
+	}
+	return d
+}
+
+// RequestStats are the request counters of the state and its daily analytics.
+type RequestStats struct {
+	LastRequest    time.Time      `json:"last_request"`
+	ImageRequests  int            `json:"image_requests"`
+	ImageRedraws   int            `json:"image_redraws"`
+	BotRequests    int            `json:"bot_requests"`
+	ApiKeyRequests map[string]int `json:"api_key_requests"`
+	// Days are the analytics of the last days, latest first
+	Days []DailyAnalytics `json:"days"`
+}
+
+// RequestStats returns a copy of the request counters, with the analytics of up to the given number of days.
+func (sm *StateManager) RequestStats(days int) RequestStats {
+	sm.Lock()
+	defer sm.Unlock()
+
+	stats := RequestStats{
+		LastRequest:    sm.state.LastRequest,
+		ImageRequests:  sm.state.ImageRequests,
+		ImageRedraws:   sm.state.ImageRedraws,
+		BotRequests:    sm.state.BotRequests,
+		ApiKeyRequests: maps.Clone(sm.state.ApiKeyRequests),
+		Days:           make([]DailyAnalytics, 0, min(days, len(sm.state.Analytics))),
+	}
+	for _, d := range slices.Backward(sm.state.Analytics) {
+		if len(stats.Days) == days {
+			break
+		}
+		stats.Days = append(stats.Days, d.clone())
+	}
+	return stats
+}
@@ -0,0 +1,141 @@
+/*
+ * Bude Seapool temperature widget
+ *
+ * <script src="https://spt.tsak.dev/widget.js" data-size="medium" data-unit="c" data-theme="light" async></script>
+ *
+ * data-size: small, medium or large
+ * data-unit: c or f
+ * data-theme: light or dark
+ */
+(function () {
+    var script = document.currentScript;
+    if (!script) {
+        return;
+    }
+
+    var origin = new URL(script.src, window.location.href).origin;
+    var size = script.getAttribute("data-size") || "medium";
+    var unit = (script.getAttribute("data-unit") || "c").toLowerCase() === "f" ? "f" : "c";
+    var theme = script.getAttribute("data-theme") === "dark" ? "dark" : "light";
+
+    // Change over the last hour that counts as rising or falling
+    var TREND_THRESHOLD = 0.2;
+    var REFRESH_INTERVAL = 10 * 60 * 1000;
+    // Readings requested, enough for the trend
+    var TREND_RANGE = 2 * 60 * 60 * 1000;
+
+    var sizes = {
+        small: {font: 14, temperature: 24},
+        medium: {font: 16, temperature: 40},
+        large: {font: 20, temperature: 64}
+    };
+    var themes = {
+        light: {background: "#ffffff", color: "#000000", muted: "#595959", border: "#d9d9d9"},
+        dark: {background: "#1a1a1a", color: "#ffffff", muted: "#b3b3b3", border: "#404040"}
+    };
+    var s = sizes[size] || sizes.medium;
+    var t = themes[theme];
+
+    var host = document.createElement("div");
+    script.parentNode.insertBefore(host, script.nextSibling);
+    var root = host.attachShadow ? host.attachShadow({mode: "open"}) : host;
+    // Unique, as without shadow DOM the ids of several widgets on a page share the document
+    var titleId = "spt-title-" + Math.random().toString(36).slice(2);
+
+    root.innerHTML =
+        "<style>" +
+        ".spt{display:inline-block;font-family:system-ui,sans-serif;font-size:" + s.font + "px;" +
+        "background:" + t.background + ";color:" + t.color + ";border:1px solid " + t.border + ";" +
+        "border-radius:.5em;padding:.75em 1em;line-height:1.3}" +
+        ".spt-temperature{font-size:" + s.temperature + "px;font-weight:bold}" +
+        ".spt-muted{color:" + t.muted + "}" +
+        ".spt a{color:inherit}" +
+        "</style>" +
+        "<section class=\"spt\" aria-labelledby=\"" + titleId + "\" aria-live=\"polite\">" +
+        "<div id=\"" + titleId + "\">Bude Sea Pool</div>" +
+        "<div class=\"spt-temperature\"><span class=\"spt-value\">&hellip;</span> <span class=\"spt-trend\"></span></div>" +
+        "<div class=\"spt-muted\">Updated <time class=\"spt-updated\"></time></div>" +
+        "<div class=\"spt-muted\"><a href=\"" + origin + "/\" target=\"_blank\" rel=\"noopener\">More details</a></div>" +
+        "</section>";
+
+    var value = root.querySelector(".spt-value");
+    var trend = root.querySelector(".spt-trend");
+    var updated = root.querySelector(".spt-updated");
+
+    function format(celsius) {
+        if (unit === "f") {
+            return (celsius * 9 / 5 + 32).toFixed(1) + "°F";
+        }
+        return celsius.toFixed(1) + "°C";
+    }
+
+    // Compares the latest reading with the first one at least an hour older
+    function describeTrend(readings) {
+        var latest = readings[0];
+        var latestTime = new Date(latest.datetime).getTime();
+        for (var i = 1; i < readings.length; i++) {
+            if (latestTime - new Date(readings[i].datetime).getTime() >= 60 * 60 * 1000) {
+                var change = latest.temperature - readings[i].temperature;
+                if (change >= TREND_THRESHOLD) {
+                    return {symbol: "↑", label: "rising"};
+                }
+                if (change <= -TREND_THRESHOLD) {
+                    return {symbol: "↓", label: "falling"};
+                }
+                return {symbol: "→", label: "steady"};
+            }
+        }
+        return null;
+    }
+
+    function render(readings) {
+        if (!readings || readings.length === 0) {
+            value.textContent = "No data";
+            return;
+        }
+
+        var latest = readings[0];
+        var date = new Date(latest.datetime);
+        value.textContent = format(latest.temperature);
+        updated.setAttribute("datetime", latest.datetime);
+        updated.textContent = date.toLocaleString(undefined, {
+            weekday: "short", hour: "2-digit", minute: "2-digit"
+        });
+
+        var direction = describeTrend(readings);
+        trend.textContent = direction ? direction.symbol : "";
+        trend.setAttribute("aria-label", direction ? direction.label : "");
+        trend.setAttribute("title", direction ? direction.label : "");
+    }
+
+    function get(path) {
+        return fetch(origin + path).then(function (response) {
+            if (!response.ok) {
+                throw new Error(response.statusText);
+            }
+            return response.json();
+        });
+    }
+
+    // Only the readings needed for the trend, to the minute so requests of all widgets can be cached. Without
+    // recent readings, the latest one is shown without a trend.
+    function load() {
+        var from = new Date(Math.floor((Date.now() - TREND_RANGE) / 60000) * 60000);
+        get("/api/v1/temperatures?from=" + encodeURIComponent(from.toISOString().replace(/\.\d+Z$/, "Z")))
+            .then(function (readings) {
+                if (readings && readings.length > 0) {
+                    return readings;
+                }
+                return get("/api/v1/temperature").then(function (latest) {
+                    return latest && latest.datetime ? [latest] : [];
+                });
+            })
+            .then(render)
+            .catch(function () {
+                value.textContent = "Unavailable";
+            });
+    }
+
+    load();
+    setInterval(load, REFRESH_INTERVAL);
+})();
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// MIN_STATS_READINGS is how many readings a past day needs for its average to be shown
+const MIN_STATS_READINGS = 12
+
+// NO_STATS is shown instead of statistics without enough readings
+const NO_STATS = "–"
+
+// DailyStats summarises the readings of one day.
+type DailyStats struct {
+	High    Reading
+	Low     Reading
+	Average float64
+	Count   int
+}
+
+// DailyStats returns the statistics of the local day containing t, or false if there are no accepted readings that day.
+func (h *History) DailyStats(t time.Time, location *time.Location) (DailyStats, bool) {
+	t = t.In(location)
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+	readings := Accepted(h.Between(start, start.AddDate(0, 0, 1)))
+	if len(readings) == 0 {
+		return DailyStats{}, false
+	}
+
+	stats := DailyStats{High: readings[0], Low: readings[0], Count: len(readings)}
+	var sum float64
+	for _, r := range readings {
+		if r.Temperature > stats.High.Temperature {
+			stats.High = r
+		}
+		if r.Temperature < stats.Low.Temperature {
+			stats.Low = r
+		}
+		sum += r.Temperature
+	}
+	stats.Average = sum / float64(len(readings))
+
+	return stats, true
+}
+
+// DisplayStats are the statistics shown on the display, formatted for the images.
+// Values without enough readings are [NO_STATS].
+type DisplayStats struct {
+	TodayHigh        string
+	TodayLow         string
+	YesterdayAverage string
+	LastYearAverage  string
+}
+
+// NewDisplayStats computes the statistics for the local day of now from the history.
+func NewDisplayStats(history *History, now time.Time, location *time.Location) DisplayStats {
+	stats := DisplayStats{
+		TodayHigh:        NO_STATS,
+		TodayLow:         NO_STATS,
+		YesterdayAverage: NO_STATS,
+		LastYearAverage:  NO_STATS,
+	}
+
+	if today, ok := history.DailyStats(now, location); ok {
+		stats.TodayHigh = formatStatsReading(today.High, location)
+		stats.TodayLow = formatStatsReading(today.Low, location)
+	}
+	if yesterday, ok := history.DailyStats(now.AddDate(0, 0, -1), location); ok && yesterday.Count >= MIN_STATS_READINGS {
+		stats.YesterdayAverage = formatStatsTemperature(yesterday.Average)
+	}
+	if lastYear, ok := history.DailyStats(now.AddDate(-1, 0, 0), location); ok && lastYear.Count >= MIN_STATS_READINGS {
+		stats.LastYearAverage = formatStatsTemperature(lastYear.Average)
+	}
+
+	return stats
+}
+
+func formatStatsReading(r Reading, location *time.Location) string {
+	return fmt.Sprintf("%s at %s", formatStatsTemperature(r.Temperature), r.Time.In(location).Format("15:04"))
+}
+
+func formatStatsTemperature(t float64) string {
+	temperature := Temperature(t)
+	return temperature.String()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+const KEEP_ALIVE_INTERVAL = 30 * time.Second
+
+// currentEvents returns the events describing the current state, which are sent to
+// new subscribers so they know what they are displaying.
+func currentEvents(readings *Readings, maintenance *Maintenance) []Event {
+	return []Event{
+		NewReadingEvent(readings.LastReading()),
+		NewMaintenanceEvent(maintenance.Message()),
+	}
+}
+
+// StreamHandler sends events to the client as Server-Sent Events.
+func StreamHandler(broker *EventBroker, readings *Readings, maintenance *Maintenance) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set("Content-Type", "text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("Connection", "keep-alive")
+		c.Set("X-Accel-Buffering", "no")
+
+		events := broker.Subscribe()
+		initial := currentEvents(readings, maintenance)
+
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer broker.Unsubscribe(events)
+
+			keepAlive := time.NewTicker(KEEP_ALIVE_INTERVAL)
+			defer keepAlive.Stop()
+
+			for _, e := range initial {
+				if err := writeServerSentEvent(w, e); err != nil {
+					return
+				}
+			}
+
+			for {
+				select {
+				case e, ok := <-events:
+					if !ok {
+						return
+					}
+					if err := writeServerSentEvent(w, e); err != nil {
+						slog.Debug("closing event stream", "error", err)
+						return
+					}
+				case <-keepAlive.C:
+					// Comments are ignored by clients, but fail once the client has gone away
+					fmt.Fprint(w, ": keep-alive\n\n")
+					if err := w.Flush(); err != nil {
+						slog.Debug("closing event stream", "error", err)
+						return
+					}
+				}
+			}
+		})
+
+		return nil
+	}
+}
+
+func writeServerSentEvent(w *bufio.Writer, e Event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+
+	return w.Flush()
+}
+
+// WebSocketUpgrade only lets WebSocket upgrade requests through to the [WebSocketHandler].
+func WebSocketUpgrade(c *fiber.Ctx) error {
+	if websocket.IsWebSocketUpgrade(c) {
+		return c.Next()
+	}
+	return fiber.ErrUpgradeRequired
+}
+
+// WebSocketHandler sends events to the client as JSON messages.
+func WebSocketHandler(broker *EventBroker, readings *Readings, maintenance *Maintenance) fiber.Handler {
+	return websocket.New(func(conn *websocket.Conn) {
+		events := broker.Subscribe()
+		defer broker.Unsubscribe(events)
+
+		// Clients don't send anything, but reading is needed to notice when they close the connection
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for _, e := range currentEvents(readings, maintenance) {
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		}
+
+		keepAlive := time.NewTicker(KEEP_ALIVE_INTERVAL)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				if err := conn.WriteJSON(e); err != nil {
+					slog.Debug("closing websocket", "error", err)
+					return
+				}
+			case <-keepAlive.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(KEEP_ALIVE_INTERVAL)); err != nil {
+					slog.Debug("closing websocket", "error", err)
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -9,19 +10,25 @@ import (
 type Temperature float64
 
 // UnmarshalJSON implements the json.Unmarshaler interface for the Temperature type.
-// It converts a JSON-encoded string to a Temperature (float64) value.
+// It converts a JSON-encoded string to a Temperature (float64) value. Values that can't be parsed
+// become NaN rather than failing the whole response, and are rejected by the [Validator].
 func (t *Temperature) UnmarshalJSON(b []byte) error {
 	s := string(b)
 	s = strings.TrimPrefix(s, `"`)
 	s = strings.TrimSuffix(s, `"`)
-	// Deliberately ignoring parse errors here
-	f, _ := strconv.ParseFloat(s, 64)
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		f = math.NaN()
+	}
 	*t = Temperature(f)
 	return nil
 }
 
-// MarshalJSON formats a temperature value as a stringified float with one decimal.
+// MarshalJSON formats a temperature value as a stringified float with one decimal, or null if it is NaN.
 func (t *Temperature) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(*t)) {
+		return []byte("null"), nil
+	}
 	return []byte(fmt.Sprintf("%.1f", *t)), nil
 }
 
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/png"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fogleman/gg"
+)
+
+// IMAGE_TYPES lists the image types every theme has to style
+var IMAGE_TYPES = []string{"temperature", "website", "tiny"}
+
+// IMAGE_MEASUREMENT is the image type showing any measured quantity, which themes may leave out
+const IMAGE_MEASUREMENT = "measurement"
+
+// Theme defines the look of all image types, both for normal operation and maintenance.
+// Themes are loaded from themes/<name>.json in the [Assets].
+type Theme struct {
+	Name        string                `json:"-"`
+	Images      map[string]ImageTheme `json:"images"`
+	Maintenance map[string]ImageTheme `json:"maintenance"`
+}
+
+// ImageTheme defines the background and the elements drawn onto one image type.
+// Portrait optionally replaces it for images that are taller than wide, and Stats
+// while the statistics panel is enabled.
+type ImageTheme struct {
+	Background Color          `json:"background"`
+	Elements   []ThemeElement `json:"elements"`
+	Portrait   *ImageTheme    `json:"portrait"`
+	Stats      *ImageTheme    `json:"stats"`
+}
+
+// ThemeElement is a text, image or shape drawn onto an image. Positions and font sizes are relative to
+// the image width and height, so themes work at any size.
+type ThemeElement struct {
+	// Name allows generators to find elements they draw themselves, like the maintenance "message"
+	Name string `json:"name"`
+
+	// Text to draw, with {temperature}, {lastModified}, {message}, {condition}, {todayHigh}, {todayLow},
+	// {yesterdayAverage}, {lastYearAverage}, and {label} and {measurement} of measurement images replaced
+	// by the current values
+	Text string `json:"text"`
+
+	// Image to draw instead of text, as a path in the assets
+	Image string `json:"image"`
+
+	// Shape to draw instead of text, "bar" draws a rounded bar of the element's width and size
+	Shape string `json:"shape"`
+
+	// Font as a path in the assets
+	Font string `json:"font"`
+
+	// Font size relative to the image height, or the height of an image element
+	Size float64 `json:"size"`
+
+	// Maximum text width relative to the image width, the font size is reduced until the text fits
+	Width float64 `json:"width"`
+
+	Color Color `json:"color"`
+
+	// Use the colour of the current swimming condition instead, the element is skipped if there is none
+	ConditionColor bool `json:"condition_color"`
+
+	// Position relative to the image width and height
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+
+	// Anchor of the element at its position, 0 is left or top, 1 is right or bottom
+	AnchorX float64 `json:"anchor_x"`
+	AnchorY float64 `json:"anchor_y"`
+}
+
+// ImageData holds the values shown on an image.
+type ImageData struct {
+	Temperature  string
+	LastModified string
+	Message      string
+
+	// Swimming condition of the temperature, and its colour
+	Condition      string
+	ConditionColor Color
+
+	// Statistics for the panel, zero unless the theme has one
+	Stats DisplayStats
+
+	// Label and value with unit of the quantity shown on measurement images
+	Label       string
+	Measurement string
+}
+
+// Color is a colour given as "#rrggbb" or "#rrggbbaa" in themes.
+type Color color.NRGBA
+
+func (c *Color) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 6 {
+		s += "ff"
+	}
+	if len(s) != 8 {
+		return fmt.Errorf("invalid colour %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return fmt.Errorf("invalid colour %q: %w", s, err)
+	}
+
+	*c = Color{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}
+	return nil
+}
+
+// MarshalJSON writes the colour as "#rrggbb", or "#rrggbbaa" if it is translucent.
+func (c Color) MarshalJSON() ([]byte, error) {
+	s := fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	if c.A != 0xff {
+		s += fmt.Sprintf("%02x", c.A)
+	}
+	return json.Marshal(s)
+}
+
+// Themes holds all loaded themes by name.
+type Themes map[string]*Theme
+
+// LoadThemes loads all themes from the themes directory in the [Assets].
+// Themes that can't be parsed or don't style every image type are skipped.
+func LoadThemes() (Themes, error) {
+	files, err := fs.Glob(Assets, "themes/*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	themes := make(Themes)
+	for _, file := range files {
+		theme, err := loadTheme(file)
+		if err != nil {
+			slog.Warn("skipping theme", "file", file, "error", err)
+			continue
+		}
+		themes[theme.Name] = theme
+	}
+
+	return themes, nil
+}
+
+func loadTheme(file string) (*Theme, error) {
+	f, err := readThemeFile(file, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decoded once the names of the palette are replaced with the colours and fonts
+	tree := map[string]any{"images": f.Images, "maintenance": f.Maintenance}
+	if err = f.resolve(tree); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+	theme := Theme{
+		Name: strings.TrimSuffix(path.Base(file), ".json"),
+	}
+	if err = json.Unmarshal(b, &theme); err != nil {
+		return nil, err
+	}
+
+	for _, imageType := range IMAGE_TYPES {
+		if _, ok := theme.Images[imageType]; !ok {
+			return nil, fmt.Errorf("missing image type %q", imageType)
+		}
+		if _, ok := theme.Maintenance[imageType]; !ok {
+			return nil, fmt.Errorf("missing maintenance image type %q", imageType)
+		}
+	}
+
+	return &theme, nil
+}
+
+// themeFile is a theme as written in its file. Colours and fonts of elements are either given directly, or as
+// names in the colors and fonts of the theme. A theme can extend another one, replacing only the colours, fonts
+// and image types it has itself, so themes can share their layout.
+type themeFile struct {
+	Extends     string            `json:"extends"`
+	Colors      map[string]string `json:"colors"`
+	Fonts       map[string]string `json:"fonts"`
+	Images      map[string]any    `json:"images"`
+	Maintenance map[string]any    `json:"maintenance"`
+}
+
+// readThemeFile reads the theme file merged with the theme it extends, if any. extending are the files of the
+// themes extending it, so cycles can be detected.
+func readThemeFile(file string, extending []string) (themeFile, error) {
+	var f themeFile
+	if slices.Contains(extending, file) {
+		return f, fmt.Errorf("%s extends itself", file)
+	}
+
+	b, err := fs.ReadFile(Assets, file)
+	if err != nil {
+		return f, err
+	}
+	if err = json.Unmarshal(b, &f); err != nil {
+		return f, err
+	}
+	if f.Extends == "" {
+		return f, nil
+	}
+
+	base, err := readThemeFile(path.Join(path.Dir(file), f.Extends+".json"), append(extending, file))
+	if err != nil {
+		return f, fmt.Errorf("extending %s: %w", f.Extends, err)
+	}
+	return themeFile{
+		Colors:      merged(base.Colors, f.Colors),
+		Fonts:       merged(base.Fonts, f.Fonts),
+		Images:      merged(base.Images, f.Images),
+		Maintenance: merged(base.Maintenance, f.Maintenance),
+	}, nil
+}
+
+// merged returns the entries of base, replaced by the ones of m with the same key.
+func merged[M ~map[K]V, K comparable, V any](base, m M) M {
+	result := make(M, len(base)+len(m))
+	maps.Copy(result, base)
+	maps.Copy(result, m)
+	return result
+}
+
+// resolve replaces the names of colours and fonts in the decoded JSON of the image types with their values.
+// Colours are names unless they start with "#", fonts unless they are paths.
+func (f themeFile) resolve(v any) error {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			s, ok := value.(string)
+			switch {
+			case ok && (key == "color" || key == "background") && !strings.HasPrefix(s, "#"):
+				c, found := f.Colors[s]
+				if !found {
+					return fmt.Errorf("unknown colour %q", s)
+				}
+				v[key] = c
+			case ok && key == "font" && !strings.Contains(s, "/"):
+				font, found := f.Fonts[s]
+				if !found {
+					return fmt.Errorf("unknown font %q", s)
+				}
+				v[key] = font
+			default:
+				if err := f.resolve(value); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		for _, value := range v {
+			if err := f.resolve(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Styles reports whether the theme styles the image type, both for normal operation and maintenance.
+func (t *Theme) Styles(imageType string) bool {
+	_, images := t.Images[imageType]
+	_, maintenance := t.Maintenance[imageType]
+	return images && maintenance
+}
+
+// ImageTheme returns the styling of the image type, using the maintenance styling while a message is set.
+func (t *Theme) ImageTheme(imageType, msg string) *ImageTheme {
+	it := t.Images[imageType]
+	if msg != "" {
+		it = t.Maintenance[imageType]
+	}
+	return &it
+}
+
+// Oriented returns the portrait styling for images taller than wide, if there is one.
+func (it *ImageTheme) Oriented(width, height int) *ImageTheme {
+	if height > width && it.Portrait != nil {
+		return it.Portrait
+	}
+	return it
+}
+
+// Element returns the element with the given name, or false if there is none.
+func (it *ImageTheme) Element(name string) (ThemeElement, bool) {
+	for _, e := range it.Elements {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return ThemeElement{}, false
+}
+
+// replacer returns a replacer of the placeholders in element texts with the values.
+func (data ImageData) replacer() *strings.Replacer {
+	return strings.NewReplacer(
+		"{temperature}", data.Temperature,
+		"{lastModified}", data.LastModified,
+		"{message}", data.Message,
+		"{condition}", data.Condition,
+		"{todayHigh}", data.Stats.TodayHigh,
+		"{todayLow}", data.Stats.TodayLow,
+		"{yesterdayAverage}", data.Stats.YesterdayAverage,
+		"{lastYearAverage}", data.Stats.LastYearAverage,
+		"{label}", data.Label,
+		"{measurement}", data.Measurement,
+	)
+}
+
+// Draw clears the context with the background and draws all elements, except those named in skip.
+func (it *ImageTheme) Draw(dc *gg.Context, data ImageData, skip ...string) error {
+	dc.SetColor(color.NRGBA(it.Background))
+	dc.Clear()
+
+	replacer := data.replacer()
+
+	width, height := float64(dc.Width()), float64(dc.Height())
+	for _, e := range it.Elements {
+		if e.Name != "" && slices.Contains(skip, e.Name) {
+			continue
+		}
+		if e.ConditionColor {
+			if data.Condition == "" {
+				continue
+			}
+			e.Color = data.ConditionColor
+		}
+
+		if e.Shape == "bar" {
+			w, h := e.Width*width, e.Size*height
+			dc.SetColor(color.NRGBA(e.Color))
+			dc.DrawRoundedRectangle(e.X*width-e.AnchorX*w, e.Y*height-e.AnchorY*h, w, h, h/2)
+			dc.Fill()
+			continue
+		}
+
+		if e.Image != "" {
+			img, err := loadImage(e.Image)
+			if err != nil {
+				return err
+			}
+			x, y := e.X*width, e.Y*height
+			dc.Push()
+			if e.Size > 0 {
+				scale := e.Size * height / float64(img.Bounds().Dy())
+				dc.ScaleAbout(scale, scale, x, y)
+			}
+			dc.DrawImageAnchored(img, int(x), int(y), e.AnchorX, e.AnchorY)
+			dc.Pop()
+			continue
+		}
+
+		text := replacer.Replace(e.Text)
+		if err := e.SetFont(dc, text); err != nil {
+			return err
+		}
+		dc.DrawStringAnchored(text, e.X*width, e.Y*height, e.AnchorX, e.AnchorY)
+	}
+
+	return nil
+}
+
+// SetFont sets the font face and colour of the element on the context. If the element has a width,
+// the font size is reduced as far as needed for the text to fit into it.
+func (e ThemeElement) SetFont(dc *gg.Context, text string) error {
+	dc.SetColor(color.NRGBA(e.Color))
+
+	points := e.Size * float64(dc.Height())
+	if e.Width > 0 && text != "" {
+		if err := LoadFontFace(dc, e.Font, points); err != nil {
+			slog.Error("unable to load font: ", "error", err)
+			return err
+		}
+		// Text width scales linearly with the font size
+		if w, _ := dc.MeasureString(text); w > e.Width*float64(dc.Width()) {
+			points *= e.Width * float64(dc.Width()) / w
+		}
+	}
+
+	if err := LoadFontFace(dc, e.Font, points); err != nil {
+		slog.Error("unable to load font: ", "error", err)
+		return err
+	}
+	return nil
+}
+
+var (
+	imagesMutex sync.Mutex
+	images      = make(map[string]image.Image)
+)
+
+// loadImage decodes an image from the [Assets], only decoding each image once.
+func loadImage(path string) (image.Image, error) {
+	imagesMutex.Lock()
+	defer imagesMutex.Unlock()
+
+	if img, ok := images[path]; ok {
+		return img, nil
+	}
+
+	f, err := Assets.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	images[path] = img
+
+	return img, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+// displaySizes are the screens the display image is shown on, landscape and portrait.
+var displaySizes = []struct{ width, height int }{
+	{2560, 1440},
+	{1920, 1080},
+	{1080, 1920},
+}
+
+// wideImageData has the widest values shown, to check they fit.
+var wideImageData = ImageData{
+	Temperature:    "-10.5°C",
+	LastModified:   "Wed, 30 Sep 2026 23:59:59",
+	Message:        "Annual maintenance#Reopens in May",
+	Condition:      "Pleasant",
+	ConditionColor: Color{R: 0x2e, G: 0x7d, B: 0x32, A: 0xff},
+}
+
+func TestImageThemeOriented(t *testing.T) {
+	themes, err := LoadThemes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(themes) == 0 {
+		t.Fatal("no themes")
+	}
+
+	for name, theme := range themes {
+		for _, msg := range []string{"", wideImageData.Message} {
+			it := theme.ImageTheme("temperature", msg)
+			if it.Portrait == nil {
+				t.Errorf("%s: no portrait styling of temperature images (message %q)", name, msg)
+				continue
+			}
+			if got := it.Oriented(1080, 1920); got != it.Portrait {
+				t.Errorf("%s: portrait styling not used for 1080×1920", name)
+			}
+			for _, size := range [][2]int{{1920, 1080}, {2560, 1440}, {1000, 1000}} {
+				if got := it.Oriented(size[0], size[1]); got != it {
+					t.Errorf("%s: portrait styling used for %d×%d", name, size[0], size[1])
+				}
+			}
+		}
+	}
+
+	// Themes without portrait styling use the same one for all sizes
+	it := &ImageTheme{}
+	if it.Oriented(1080, 1920) != it {
+		t.Error("no portrait styling, but another one used for 1080×1920")
+	}
+}
+
+func TestDisplayImageFits(t *testing.T) {
+	themes, err := LoadThemes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, theme := range themes {
+		for _, size := range displaySizes {
+			t.Run(fmt.Sprintf("%s/%dx%d", name, size.width, size.height), func(t *testing.T) {
+				for _, msg := range []string{"", wideImageData.Message} {
+					generate := GenerateDisplayImage
+					if msg != "" {
+						generate = GenerateMaintenanceDisplayImage
+					}
+					it := theme.ImageTheme("temperature", msg).Oriented(size.width, size.height)
+
+					img, err := generate(size.width, size.height, it, wideImageData)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if b := img.Bounds(); b.Dx() != size.width || b.Dy() != size.height {
+						t.Errorf("image is %d×%d", b.Dx(), b.Dy())
+					}
+
+					checkElementsFit(t, it, size.width, size.height, msg != "")
+				}
+			})
+		}
+	}
+}
+
+var update = flag.Bool("update", false, "update the golden images in testdata")
+
+// goldenTolerance is how far a colour channel may be off, as the rasterised text
+// can differ slightly between architectures.
+const goldenTolerance = 8
+
+// TestDisplayImageGolden compares the display images of the default theme with the ones in testdata,
+// which go test -run TestDisplayImageGolden -update regenerates.
+func TestDisplayImageGolden(t *testing.T) {
+	themes, err := LoadThemes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	theme, ok := themes["light"]
+	if !ok {
+		t.Fatal("no light theme")
+	}
+
+	for _, size := range displaySizes {
+		for _, msg := range []string{"", wideImageData.Message} {
+			name := fmt.Sprintf("temperature-%dx%d", size.width, size.height)
+			generate := GenerateDisplayImage
+			if msg != "" {
+				name += "-maintenance"
+				generate = GenerateMaintenanceDisplayImage
+			}
+			t.Run(name, func(t *testing.T) {
+				it := theme.ImageTheme("temperature", msg).Oriented(size.width, size.height)
+				img, err := generate(size.width, size.height, it, wideImageData)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				golden := filepath.Join("testdata", name+".png")
+				if *update {
+					var buf bytes.Buffer
+					encoder := png.Encoder{CompressionLevel: png.BestCompression}
+					if err := encoder.Encode(&buf, img); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				f, err := os.Open(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				want, err := png.Decode(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if diff := imageDiff(img, want); diff != "" {
+					t.Errorf("image differs from %s: %s, run with -update if the change is intended", golden, diff)
+				}
+			})
+		}
+	}
+}
+
+// imageDiff describes how got differs from want beyond the [goldenTolerance], or is empty if it doesn't.
+func imageDiff(got, want image.Image) string {
+	if got.Bounds() != want.Bounds() {
+		return fmt.Sprintf("bounds %v, want %v", got.Bounds(), want.Bounds())
+	}
+
+	b := got.Bounds()
+	var differ int
+	first := image.Point{-1, -1}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA)
+			w := color.NRGBAModel.Convert(want.At(x, y)).(color.NRGBA)
+			if channelDiff(g.R, w.R) > goldenTolerance || channelDiff(g.G, w.G) > goldenTolerance ||
+				channelDiff(g.B, w.B) > goldenTolerance || channelDiff(g.A, w.A) > goldenTolerance {
+				if differ == 0 {
+					first = image.Pt(x, y)
+				}
+				differ++
+			}
+		}
+	}
+	if differ == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d pixels differ, the first at %v", differ, first)
+}
+
+func channelDiff(a, b uint8) int {
+	return max(int(a)-int(b), int(b)-int(a))
+}
+
+// checkElementsFit checks the text and bars of the styling stay inside the canvas, and that text
+// with a width isn't wider than it. On maintenance images the message is checked as it is drawn,
+// on one or two lines.
+func checkElementsFit(t *testing.T, it *ImageTheme, width, height int, maintenance bool) {
+	t.Helper()
+
+	dc := gg.NewContext(width, height)
+	replacer := wideImageData.replacer()
+	w, h := float64(width), float64(height)
+	for _, e := range it.Elements {
+		if e.Image != "" {
+			continue
+		}
+
+		// Bars are anchored by their box, text by its baseline
+		var ew, eh, top float64
+		anchorX := e.AnchorX
+		switch {
+		case e.Shape == "bar":
+			ew, eh = e.Width*w, e.Size*h
+			top = e.Y*h - e.AnchorY*eh
+		case maintenance && e.Name == "message":
+			parts := strings.Split(wideImageData.Message, "#")
+			if len(parts) > 2 {
+				// Wrapped inside the padding of the canvas
+				continue
+			}
+			// Sized to fit the wider line, as it is drawn
+			if err := e.SetFont(dc, ""); err != nil {
+				t.Fatal(err)
+			}
+			widest := slices.MaxFunc(parts, func(a, b string) int {
+				wa, _ := dc.MeasureString(a)
+				wb, _ := dc.MeasureString(b)
+				return cmp.Compare(wa, wb)
+			})
+			if err := e.SetFont(dc, widest); err != nil {
+				t.Fatal(err)
+			}
+			ew, eh = dc.MeasureString(widest)
+			if e.Width > 0 && ew > e.Width*w+1 {
+				t.Errorf("%s is %.0f wide, more than its %.0f", e.Name, ew, e.Width*w)
+			}
+			// Centred on its point, two lines from 1.25 line heights above it to below it
+			anchorX, top = 0.5, e.Y*h-eh/2
+			if len(parts) == 2 {
+				top, eh = e.Y*h-1.25*eh, 2.5*eh
+			}
+		default:
+			text := replacer.Replace(e.Text)
+			if text == "" {
+				continue
+			}
+			if err := e.SetFont(dc, text); err != nil {
+				t.Fatal(err)
+			}
+			ew, eh = dc.MeasureString(text)
+			if e.Width > 0 && ew > e.Width*w+1 {
+				t.Errorf("%s is %.0f wide, more than its %.0f", e.Name, ew, e.Width*w)
+			}
+			top = e.Y*h + e.AnchorY*eh - eh
+		}
+
+		left := e.X*w - anchorX*ew
+		if left < 0 || top < 0 || left+ew > w || top+eh > h {
+			t.Errorf("%s at (%.0f, %.0f) to (%.0f, %.0f) is outside the canvas", e.Name, left, top, left+ew, top+eh)
+		}
+	}
+}
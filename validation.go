@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// Reasons readings are rejected for
+const (
+	REJECT_UNPARSEABLE = "unparseable"
+	REJECT_RANGE       = "out_of_range"
+	REJECT_RATE        = "rate_of_change"
+	REJECT_MEDIAN      = "median_deviation"
+	// REJECT_NO_TEMPERATURE is for readings of sensors measuring other quantities only, e.g. pH
+	REJECT_NO_TEMPERATURE = "no_temperature"
+)
+
+const (
+	// MIN_RATE_INTERVAL is the shortest time the rate of change is calculated over, so readings taken
+	// in quick succession aren't rejected for small differences
+	MIN_RATE_INTERVAL = 10 * time.Minute
+
+	// MIN_MEDIAN_READINGS is how many previous readings the median filter needs
+	MIN_MEDIAN_READINGS = 3
+)
+
+// Validator checks new readings before they are shown, rejecting glitches of the sensor. Checks with a
+// limit of zero are disabled.
+type Validator struct {
+	// Physically plausible range of temperatures, in °C
+	MinTemperature float64
+	MaxTemperature float64
+
+	// MaxRate is the largest change since the previous reading, in °C per hour
+	MaxRate float64
+
+	// MaxDeviation is the largest difference to the median of the readings within MedianWindow before, in °C
+	MaxDeviation float64
+	MedianWindow time.Duration
+}
+
+// NewValidator creates the validator configured by the VALIDATION_* settings.
+func NewValidator(cfg *Config) Validator {
+	return Validator{
+		MinTemperature: cfg.ValidationMinTemperature,
+		MaxTemperature: cfg.ValidationMaxTemperature,
+		MaxRate:        cfg.ValidationMaxRate,
+		MaxDeviation:   cfg.ValidationMaxDeviation,
+		MedianWindow:   cfg.ValidationMedianWindow,
+	}
+}
+
+// InRange checks if the temperature is within the physically plausible range.
+func (v Validator) InRange(temperature float64) bool {
+	return temperature >= v.MinTemperature && temperature <= v.MaxTemperature
+}
+
+// Check returns why the reading is rejected, or an empty string if it is valid. previous are the accepted
+// readings of the same source before it, oldest first, going back at least MedianWindow.
+func (v Validator) Check(r Reading, previous []Reading) string {
+	if r.Rejected != "" {
+		return r.Rejected
+	}
+	if math.IsNaN(r.Temperature) {
+		return REJECT_UNPARSEABLE
+	}
+	if !v.InRange(r.Temperature) {
+		return REJECT_RANGE
+	}
+	if len(previous) == 0 {
+		return ""
+	}
+
+	last := previous[len(previous)-1]
+	if v.MaxRate > 0 {
+		hours := max(r.Time.Sub(last.Time), MIN_RATE_INTERVAL).Hours()
+		if math.Abs(r.Temperature-last.Temperature) > v.MaxRate*hours {
+			return REJECT_RATE
+		}
+	}
+
+	if v.MaxDeviation > 0 {
+		var window []float64
+		for _, p := range previous {
+			if r.Time.Sub(p.Time) <= v.MedianWindow {
+				window = append(window, p.Temperature)
+			}
+		}
+		// Without enough recent readings, e.g. after the sensor was offline, there's nothing to compare to
+		if len(window) >= MIN_MEDIAN_READINGS && math.Abs(r.Temperature-median(window)) > v.MaxDeviation {
+			return REJECT_MEDIAN
+		}
+	}
+
+	return ""
+}
+
+func median(values []float64) float64 {
+	slices.Sort(values)
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+	return values[middle]
+}
+
+// Accepted returns the readings that weren't rejected.
+func Accepted(readings []Reading) []Reading {
+	if !slices.ContainsFunc(readings, Reading.IsRejected) {
+		return readings
+	}
+	return slices.DeleteFunc(slices.Clone(readings), Reading.IsRejected)
+}
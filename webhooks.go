@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// WEBHOOK_MAX_ATTEMPTS is how often a delivery is attempted before it is marked as failed
+	WEBHOOK_MAX_ATTEMPTS = 6
+
+	// WEBHOOK_RETRY_DELAY is the delay before the first retry, which doubles with every further one
+	WEBHOOK_RETRY_DELAY = 30 * time.Second
+
+	WEBHOOK_TIMEOUT = 10 * time.Second
+
+	// MAX_WEBHOOK_DELIVERIES is how many deliveries are kept in the delivery log
+	MAX_WEBHOOK_DELIVERIES = 1000
+
+	WEBHOOK_EVENT_HEADER     = "X-Webhook-Event"
+	WEBHOOK_DELIVERY_HEADER  = "X-Webhook-Delivery"
+	WEBHOOK_SIGNATURE_HEADER = "X-Webhook-Signature"
+
+	DELIVERY_PENDING   = "pending"
+	DELIVERY_DELIVERED = "delivered"
+	DELIVERY_FAILED    = "failed"
+)
+
+var ErrDeliveryNotFound = errors.New("delivery not found")
+var ErrDeliveryNotFailed = errors.New("only failed deliveries can be replayed")
+
+// WebhookSubscription is a partner system notified of events. Without Events it receives all of them.
+type WebhookSubscription struct {
+	Name   string   `json:"name"`
+	Url    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events,omitempty"`
+}
+
+// Wants returns whether the subscription receives events of the type.
+func (s WebhookSubscription) Wants(eventType string) bool {
+	return len(s.Events) == 0 || slices.Contains(s.Events, eventType)
+}
+
+// LoadWebhookSubscriptions loads the subscriptions from the JSON file, or returns none if no file is given.
+func LoadWebhookSubscriptions(file string) ([]WebhookSubscription, error) {
+	if file == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions []WebhookSubscription
+	if err = json.Unmarshal(b, &subscriptions); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, s := range subscriptions {
+		if s.Name == "" || names[s.Name] {
+			return nil, fmt.Errorf("webhook subscriptions need a unique name, got %q", s.Name)
+		}
+		names[s.Name] = true
+		if u, err := url.Parse(s.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid URL %q of webhook subscription %q", s.Url, s.Name)
+		}
+		if s.Secret == "" {
+			return nil, fmt.Errorf("webhook subscription %q has no secret", s.Name)
+		}
+		for _, e := range s.Events {
+			if e != EVENT_READING && e != EVENT_MAINTENANCE {
+				return nil, fmt.Errorf("unknown event %q of webhook subscription %q", e, s.Name)
+			}
+		}
+	}
+
+	return subscriptions, nil
+}
+
+// WebhookPayload is the body posted to subscribers. Its Id stays the same when a delivery is replayed,
+// so subscribers can recognise events they have already handled.
+type WebhookPayload struct {
+	Id        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// WebhookDelivery is the delivery of an event to one subscription, with all attempts made so far.
+type WebhookDelivery struct {
+	Id            string           `json:"id"`
+	Subscription  string           `json:"subscription"`
+	Url           string           `json:"url"`
+	Event         string           `json:"event"`
+	Payload       json.RawMessage  `json:"payload"`
+	Status        string           `json:"status"`
+	CreatedAt     time.Time        `json:"created_at"`
+	NextAttemptAt *time.Time       `json:"next_attempt_at,omitempty"`
+	Attempts      []WebhookAttempt `json:"attempts"`
+	// ReplayOf is the ID of the failed delivery this one replays
+	ReplayOf string `json:"replay_of,omitempty"`
+}
+
+// WebhookAttempt is a single request of a delivery. It succeeded if the subscriber responded with a 2xx status.
+type WebhookAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Webhooks posts events to the subscriptions, retrying failed deliveries with exponential backoff.
+// Deliveries are logged to a JSON file, so pending ones are resumed after a restart.
+type Webhooks struct {
+	sync.Mutex
+	subscriptions []WebhookSubscription
+	filename      string
+	deliveries    []*WebhookDelivery
+	client        *http.Client
+}
+
+// NewWebhooks loads the delivery log from the file, if it exists, and resumes pending deliveries.
+func NewWebhooks(subscriptions []WebhookSubscription, filename string) (*Webhooks, error) {
+	w := Webhooks{
+		subscriptions: subscriptions,
+		filename:      filename,
+		client:        &http.Client{Timeout: WEBHOOK_TIMEOUT},
+	}
+
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return &w, nil
+	}
+	if err != nil {
+		return &w, err
+	}
+	if err = json.Unmarshal(b, &w.deliveries); err != nil {
+		return &w, err
+	}
+	for _, d := range w.deliveries {
+		if d.Status == DELIVERY_PENDING {
+			go w.deliver(d)
+		}
+	}
+
+	return &w, nil
+}
+
+// Publish creates a delivery of the event for every subscription that wants it.
+func (w *Webhooks) Publish(e Event) {
+	if len(w.subscriptions) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	payload, err := json.Marshal(WebhookPayload{
+		Id:        rand.Text(),
+		Type:      e.Type,
+		CreatedAt: now,
+		Data:      e.Data,
+	})
+	if err != nil {
+		slog.Error("unable to encode webhook payload", "error", err, "event", e.Type)
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	for _, s := range w.subscriptions {
+		if !s.Wants(e.Type) {
+			continue
+		}
+		d := w.add(&WebhookDelivery{
+			Id:           rand.Text(),
+			Subscription: s.Name,
+			Url:          s.Url,
+			Event:        e.Type,
+			Payload:      payload,
+			CreatedAt:    now,
+		})
+		go w.deliver(d)
+	}
+	w.save()
+}
+
+// add adds the delivery to the log as pending, dropping the oldest finished deliveries once the log is full.
+// Must be called with the lock held.
+func (w *Webhooks) add(d *WebhookDelivery) *WebhookDelivery {
+	d.Status = DELIVERY_PENDING
+	d.NextAttemptAt = &d.CreatedAt
+	w.deliveries = append(w.deliveries, d)
+
+	for excess := len(w.deliveries) - MAX_WEBHOOK_DELIVERIES; excess > 0; excess-- {
+		i := slices.IndexFunc(w.deliveries, func(d *WebhookDelivery) bool {
+			return d.Status != DELIVERY_PENDING
+		})
+		if i < 0 {
+			break
+		}
+		w.deliveries = slices.Delete(w.deliveries, i, i+1)
+	}
+
+	return d
+}
+
+// deliver attempts the delivery until it succeeds or runs out of attempts.
+func (w *Webhooks) deliver(d *WebhookDelivery) {
+	for {
+		w.Lock()
+		next := *d.NextAttemptAt
+		subscription, found := w.subscription(d.Subscription)
+		w.Unlock()
+
+		time.Sleep(time.Until(next))
+		attempt := WebhookAttempt{Time: time.Now().UTC(), Error: "subscription no longer exists"}
+		if found {
+			attempt = w.attempt(subscription, d)
+		}
+
+		w.Lock()
+		d.Attempts = append(d.Attempts, attempt)
+		switch {
+		case attempt.Error == "":
+			d.Status = DELIVERY_DELIVERED
+			d.NextAttemptAt = nil
+		case !found || len(d.Attempts) >= WEBHOOK_MAX_ATTEMPTS:
+			d.Status = DELIVERY_FAILED
+			d.NextAttemptAt = nil
+		default:
+			next := attempt.Time.Add(WEBHOOK_RETRY_DELAY << (len(d.Attempts) - 1))
+			d.NextAttemptAt = &next
+		}
+		status := d.Status
+		w.save()
+		w.Unlock()
+
+		switch status {
+		case DELIVERY_DELIVERED:
+			slog.Debug("delivered webhook", "delivery", d.Id, "subscription", d.Subscription, "event", d.Event)
+			return
+		case DELIVERY_FAILED:
+			slog.Warn("webhook delivery failed", "delivery", d.Id, "subscription", d.Subscription, "error", attempt.Error)
+			return
+		}
+		slog.Info("webhook delivery attempt failed, retrying", "delivery", d.Id, "subscription", d.Subscription, "error", attempt.Error)
+	}
+}
+
+// attempt posts the payload to the subscription, signed with its secret.
+func (w *Webhooks) attempt(s WebhookSubscription, d *WebhookDelivery) WebhookAttempt {
+	attempt := WebhookAttempt{Time: time.Now().UTC()}
+
+	req, err := http.NewRequest(http.MethodPost, s.Url, bytes.NewReader(d.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bude-seapool-temperature")
+	req.Header.Set(WEBHOOK_EVENT_HEADER, d.Event)
+	req.Header.Set(WEBHOOK_DELIVERY_HEADER, d.Id)
+	req.Header.Set(WEBHOOK_SIGNATURE_HEADER, WebhookSignature(s.Secret, attempt.Time, d.Payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = resp.Status
+	}
+	return attempt
+}
+
+// WebhookSignature signs the payload for the [WEBHOOK_SIGNATURE_HEADER] as "t=<unix time>,sha256=<hex HMAC>".
+// The HMAC-SHA256 is calculated over the unix time, a dot and the payload, so old requests can't be replayed.
+func WebhookSignature(secret string, t time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return fmt.Sprintf("t=%s,sha256=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// subscription returns the subscription with the name. Must be called with the lock held.
+func (w *Webhooks) subscription(name string) (WebhookSubscription, bool) {
+	i := slices.IndexFunc(w.subscriptions, func(s WebhookSubscription) bool {
+		return s.Name == name
+	})
+	if i < 0 {
+		return WebhookSubscription{}, false
+	}
+	return w.subscriptions[i], true
+}
+
+// Deliveries returns copies of the deliveries with the status, or all of them if status is empty, latest first.
+func (w *Webhooks) Deliveries(status string) []WebhookDelivery {
+	w.Lock()
+	defer w.Unlock()
+
+	deliveries := []WebhookDelivery{}
+	for _, d := range slices.Backward(w.deliveries) {
+		if status == "" || d.Status == status {
+			deliveries = append(deliveries, d.clone())
+		}
+	}
+	return deliveries
+}
+
+// Replay delivers the payload of a failed delivery again, as a new delivery to the subscription's current URL.
+func (w *Webhooks) Replay(id string) (WebhookDelivery, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	i := slices.IndexFunc(w.deliveries, func(d *WebhookDelivery) bool {
+		return d.Id == id
+	})
+	if i < 0 {
+		return WebhookDelivery{}, ErrDeliveryNotFound
+	}
+	failed := w.deliveries[i]
+	if failed.Status != DELIVERY_FAILED {
+		return WebhookDelivery{}, ErrDeliveryNotFailed
+	}
+	s, ok := w.subscription(failed.Subscription)
+	if !ok {
+		return WebhookDelivery{}, fmt.Errorf("subscription %q no longer exists", failed.Subscription)
+	}
+
+	d := w.add(&WebhookDelivery{
+		Id:           rand.Text(),
+		Subscription: s.Name,
+		Url:          s.Url,
+		Event:        failed.Event,
+		Payload:      failed.Payload,
+		CreatedAt:    time.Now().UTC(),
+		ReplayOf:     failed.Id,
+	})
+	go w.deliver(d)
+	w.save()
+
+	return d.clone(), nil
+}
+
+func (d *WebhookDelivery) clone() WebhookDelivery {
+	c := *d
+	c.Attempts = slices.Clone(d.Attempts)
+	return c
+}
+
+// save writes the delivery log to a new file, replacing the old one once it is written. Must be called with the lock held.
+func (w *Webhooks) save() {
+	b, err := json.Marshal(w.deliveries)
+	if err == nil {
+		err = os.WriteFile(w.filename+".tmp", b, 0644)
+	}
+	if err == nil {
+		err = os.Rename(w.filename+".tmp", w.filename)
+	}
+	if err != nil {
+		slog.Error("unable to save webhook deliveries", "error", err, "filename", w.filename)
+	}
+}